@@ -1,22 +1,94 @@
 package main
 
 import (
+	"embed"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
+// public is served when no directory is given
+//
+//go:embed public
+var public embed.FS
+
 func dynamicHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "hello from dynamic ", time.Now().String())
 }
 
-func staticHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "s.html")
-}
-
 func main() {
+	dir := flag.String("dir", "", "directory to serve (default: embedded public directory)")
+	listing := flag.Bool("listing", false, "list directories without index.html")
+	spa := flag.String("spa", "", "file served for unknown extensionless paths, like index.html of a single page app (empty disables)")
+	var rules []cacheRule
+	flag.Func("cache", `Cache-Control rule "glob=value", first matching rule wins (repeatable)`, func(s string) error {
+		rule, err := parseCacheRule(s)
+		if err == nil {
+			rules = append(rules, rule)
+		}
+		return err
+	})
+	flag.Parse()
+
+	var fsys fs.FS
+	if *dir != "" {
+		fsys = os.DirFS(*dir)
+	} else {
+		sub, err := fs.Sub(public, "public")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fsys = sub
+	}
+	if *spa != "" {
+		if _, err := fs.Stat(fsys, *spa); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	fmt.Println("Started server")
-	http.HandleFunc("/static", staticHandler)
+	http.Handle("/static/", http.StripPrefix("/static", newStaticSite(fsys, *listing, *spa, rules)))
 	http.HandleFunc("/", dynamicHandler)
 	http.ListenAndServe("localhost:8080", nil)
 }
+
+/*
+$ go run *.go -cache '*.html=no-cache' -cache 'assets/*=public, max-age=31536000, immutable'
+Started server
+
+$ curl -i localhost:8080/static/s.html
+HTTP/1.1 200 OK
+Accept-Ranges: bytes
+Cache-Control: no-cache
+Content-Length: 18
+Content-Type: text/html; charset=utf-8
+Etag: "HMzy15wpmsmHK0lvQ6j4ix5T"
+Last-Modified: Wed, 17 Feb 2021 09:11:54 GMT
+Vary: Accept-Encoding
+Date: Wed, 17 Feb 2021 09:12:30 GMT
+
+hello from static
+
+# strong etag and range requests
+$ curl -i -H 'If-None-Match: "HMzy15wpmsmHK0lvQ6j4ix5T"' localhost:8080/static/s.html
+HTTP/1.1 304 Not Modified
+$ curl -H 'Range: bytes=6-9' localhost:8080/static/s.html
+from
+
+# precompressed variants are picked by Accept-Encoding, br first
+$ gzip -k site/app.js && brotli -k site/app.js
+$ go run *.go -dir site
+$ curl -sI -H 'Accept-Encoding: gzip, br' localhost:8080/static/app.js | grep Content-Encoding
+Content-Encoding: br
+
+# directory listing is off by default, spa fallback serves index.html for client side routes
+$ go run *.go -listing -spa index.html
+$ curl -s localhost:8080/static/docs/getting-started | head -1
+<!doctype html>
+$ curl -s -o /dev/null -w '%{http_code}\n' localhost:8080/static/missing.js
+404
+*/
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// encodings of precompressed variants in order of preference, file.html.br is tried before file.html.gz
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// fallbackModTime stands in for modification time of files that have none, like those of embed.FS.
+// the executable is rebuilt whenever embedded files change, its modification time is used when known.
+var fallbackModTime = func() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Now()
+}()
+
+// cacheRule sets Cache-Control of files matching the glob
+type cacheRule struct {
+	glob  string // path.Match pattern, matched against the base name unless it contains a slash
+	value string
+}
+
+// staticSite serves files of fsys with validators, ranges and precompressed variants
+type staticSite struct {
+	fsys    fs.FS
+	listing bool        // list directories without index.html
+	spa     string      // file served for unknown extensionless paths, empty disables the fallback
+	rules   []cacheRule // first matching rule wins
+
+	mu    sync.Mutex
+	etags map[string]etagEntry // by file name
+}
+
+// etagEntry is a computed etag valid while the file keeps its size and modification time
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// newStaticSite creates handler of fsys
+func newStaticSite(fsys fs.FS, listing bool, spa string, rules []cacheRule) *staticSite {
+	return &staticSite{fsys: fsys, listing: listing, spa: spa, rules: rules, etags: make(map[string]etagEntry)}
+}
+
+func (s *staticSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	upath := path.Clean("/" + r.URL.Path)
+	name := strings.TrimPrefix(upath, "/")
+	if name == "" {
+		name = "."
+	}
+	if hidden(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		s.fallback(w, r, upath)
+		return
+	}
+	if !info.IsDir() {
+		s.serveFile(w, r, name)
+		return
+	}
+
+	// relative redirect keeps working behind http.StripPrefix,
+	// http.Redirect would resolve it against the stripped path
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := path.Base(upath) + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+	index := path.Join(name, "index.html")
+	if info, err := fs.Stat(s.fsys, index); err == nil && !info.IsDir() {
+		s.serveFile(w, r, index)
+		return
+	}
+	if s.listing {
+		s.serveListing(w, r, name)
+		return
+	}
+	s.fallback(w, r, upath)
+}
+
+// hidden reports whether any element of the name starts with a dot, such files are never served
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// fallback serves the spa file for paths that look like client side routes, anything else is not found
+func (s *staticSite) fallback(w http.ResponseWriter, r *http.Request, upath string) {
+	if s.spa == "" || path.Ext(upath) != "" {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, s.spa)
+}
+
+// serveFile picks the best encoded variant of the file accepted by the client and serves it.
+// ranges and conditional requests are handled by http.ServeContent.
+func (s *staticSite) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	variant, encoding := name, ""
+	for _, enc := range encodings {
+		if !accepts(r.Header.Get("Accept-Encoding"), enc.name) {
+			continue
+		}
+		if info, err := fs.Stat(s.fsys, name+enc.ext); err == nil && !info.IsDir() {
+			variant, encoding = name+enc.ext, enc.name
+			break
+		}
+	}
+
+	f, err := s.fsys.Open(variant)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "500 - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "500 - file is not seekable", http.StatusInternalServerError)
+		return
+	}
+	etag, err := s.etag(variant, info, content)
+	if err != nil {
+		http.Error(w, "500 - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	h.Set("ETag", etag)
+	if value := s.cacheControl(name); value != "" {
+		h.Set("Cache-Control", value)
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		// content sniffing would look at compressed bytes
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+	}
+	if ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	modTime := info.ModTime()
+	// embed.FS has no modification times, without one no Last-Modified is sent
+	if modTime.IsZero() {
+		modTime = fallbackModTime
+	}
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// accepts reports whether Accept-Encoding header allows the encoding, q=0 refuses it.
+// the encoding listed by name takes precedence over *.
+func accepts(header, encoding string) bool {
+	named, star := -1.0, -1.0
+	for _, item := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, encoding) && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "*" {
+			star = q
+		} else {
+			named = q
+		}
+	}
+	if named >= 0 {
+		return named > 0
+	}
+	return star > 0
+}
+
+// etag returns strong etag of the file content, hashes are recomputed only when the file changes
+func (s *staticSite) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	s.mu.Lock()
+	entry, ok := s.etags[name]
+	s.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`
+
+	s.mu.Lock()
+	s.etags[name] = etagEntry{info.Size(), info.ModTime(), etag}
+	s.mu.Unlock()
+	return etag, nil
+}
+
+// cacheControl returns Cache-Control value of the first rule matching the file
+func (s *staticSite) cacheControl(name string) string {
+	for _, rule := range s.rules {
+		target := path.Base(name)
+		if strings.Contains(rule.glob, "/") {
+			target = name
+		}
+		if ok, _ := path.Match(rule.glob, target); ok {
+			return rule.value
+		}
+	}
+	return ""
+}
+
+// parseCacheRule parses "glob=value" flag value
+func parseCacheRule(s string) (cacheRule, error) {
+	glob, value, ok := strings.Cut(s, "=")
+	if !ok || glob == "" || value == "" {
+		return cacheRule{}, errors.New(`cache rule must look like "glob=value"`)
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return cacheRule{}, fmt.Errorf("%q: %s", glob, err)
+	}
+	return cacheRule{glob, value}, nil
+}
+
+// listingTemplate renders directory listing
+var listingTemplate = template.Must(template.New("listing").Parse(`<!doctype html>
+<title>{{.Dir}}</title>
+<h1>{{.Dir}}</h1>
+<pre>
+{{range .Entries}}<a href="{{.Href}}">{{.Name}}</a>
+{{end}}</pre>
+`))
+
+// listingEntry is a single link of the listing
+type listingEntry struct {
+	Name string
+	Href string
+}
+
+// serveListing renders directory entries, hidden files are left out
+func (s *staticSite) serveListing(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		http.Error(w, "500 - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var links []listingEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		entry := e.Name()
+		if e.IsDir() {
+			entry += "/"
+		}
+		links = append(links, listingEntry{entry, "./" + entry})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	listingTemplate.Execute(w, struct {
+		Dir     string
+		Entries []listingEntry
+	}{"/" + strings.TrimPrefix(name, "."), links})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		want     bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"deflate, gzip;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"gzip ; q=0.000", "gzip", false},
+		{"*", "br", true},
+		{"*;q=0", "br", false},
+		// the encoding listed by name wins over *, wherever it stands
+		{"*;q=0, gzip", "gzip", true},
+		{"gzip, *;q=0", "gzip", true},
+		{"br;q=0, *", "br", false},
+		{"*, br;q=0", "br", false},
+		{"br;q=0, *", "gzip", true},
+		{"x-gzip", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := accepts(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("accepts(%q, %q) = %v, want %v", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestStaticSite(t *testing.T) {
+	modTime := time.Date(2021, 2, 16, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		// no modification time, like files of embed.FS
+		"index.html":     {Data: []byte("index")},
+		"s.html":         {Data: []byte("hello from static\n")},
+		"s.html.gz":      {Data: []byte("gzipped")},
+		"s.html.br":      {Data: []byte("brotli")},
+		"assets/app.js":  {Data: []byte("app"), ModTime: modTime},
+		"docs/guide.txt": {Data: []byte("guide"), ModTime: modTime},
+		"docs/.secret":   {Data: []byte("secret"), ModTime: modTime},
+		".git/config":    {Data: []byte("config"), ModTime: modTime},
+	}
+	site := newStaticSite(fsys, false, "index.html", []cacheRule{
+		{"*.html", "no-cache"},
+		{"assets/*", "public, max-age=31536000, immutable"},
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		header   []string
+		status   int
+		body     string
+		encoding string
+		cache    string
+	}{
+		{"file", "/s.html", nil, http.StatusOK, "hello from static\n", "", "no-cache"},
+		{"brotli preferred", "/s.html", []string{"Accept-Encoding", "gzip, br"}, http.StatusOK, "brotli", "br", "no-cache"},
+		{"gzip", "/s.html", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "gzipped", "gzip", "no-cache"},
+		{"brotli refused", "/s.html", []string{"Accept-Encoding", "br;q=0, *"}, http.StatusOK, "gzipped", "gzip", "no-cache"},
+		{"range", "/s.html", []string{"Range", "bytes=6-9"}, http.StatusPartialContent, "from", "", "no-cache"},
+		{"rule with a slash", "/assets/app.js", nil, http.StatusOK, "app", "", "public, max-age=31536000, immutable"},
+		{"index", "/", nil, http.StatusOK, "index", "", "no-cache"},
+		{"directory redirect", "/docs?x=1", nil, http.StatusMovedPermanently, "", "", ""},
+		{"no listing", "/docs/", nil, http.StatusOK, "index", "", "no-cache"},
+		{"spa fallback", "/settings/profile", nil, http.StatusOK, "index", "", "no-cache"},
+		{"missing file", "/missing.css", nil, http.StatusNotFound, "", "", ""},
+		{"hidden file", "/docs/.secret", nil, http.StatusNotFound, "", "", ""},
+		{"hidden directory", "/.git/config", nil, http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for i := 0; i < len(tt.header); i += 2 {
+				req.Header.Set(tt.header[i], tt.header[i+1])
+			}
+			rec := httptest.NewRecorder()
+			site.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusMovedPermanently {
+				if loc := rec.Header().Get("Location"); loc != "docs/?x=1" {
+					t.Errorf("Location = %q, want docs/?x=1", loc)
+				}
+				return
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body, tt.body)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cache)
+			}
+		})
+	}
+}
+
+func TestStaticSiteValidators(t *testing.T) {
+	modTime := time.Date(2021, 2, 16, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"dated.txt":    {Data: []byte("dated"), ModTime: modTime},
+		"embedded.txt": {Data: []byte("embedded")},
+	}
+	site := newStaticSite(fsys, false, "", nil)
+	get := func(name string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		site.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		lastModified string
+	}{
+		{"dated.txt", modTime.Format(http.TimeFormat)},
+		// files without modification time fall back to a fixed one
+		{"embedded.txt", fallbackModTime.UTC().Format(http.TimeFormat)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.name)
+			if got := rec.Header().Get("Last-Modified"); got != tt.lastModified {
+				t.Errorf("Last-Modified = %q, want %q", got, tt.lastModified)
+			}
+			etag := rec.Header().Get("ETag")
+			if len(etag) < 3 || etag[0] != '"' {
+				t.Fatalf("ETag = %q, want a strong etag", etag)
+			}
+			if rec := get(tt.name, "If-None-Match", etag); rec.Code != http.StatusNotModified {
+				t.Errorf("If-None-Match: status = %d, want 304", rec.Code)
+			}
+			if rec := get(tt.name, "If-Modified-Since", tt.lastModified); rec.Code != http.StatusNotModified {
+				t.Errorf("If-Modified-Since: status = %d, want 304", rec.Code)
+			}
+			if rec := get(tt.name, "If-None-Match", `"other"`); rec.Code != http.StatusOK {
+				t.Errorf("other etag: status = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
+
+func TestStaticSiteListing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/guide.txt":  {Data: []byte("guide")},
+		"docs/.secret":    {Data: []byte("secret")},
+		"docs/api/v1.txt": {Data: []byte("v1")},
+	}
+	rec := httptest.NewRecorder()
+	newStaticSite(fsys, true, "", nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	want := "<!doctype html>\n<title>/docs</title>\n<h1>/docs</h1>\n<pre>\n" +
+		"<a href=\"./api/\">api/</a>\n<a href=\"./guide.txt\">guide.txt</a>\n</pre>\n"
+	if rec.Body.String() != want {
+		t.Errorf("listing =\n%s\nwant\n%s", rec.Body, want)
+	}
+}
+
+func TestParseCacheRule(t *testing.T) {
+	tests := []struct {
+		flag    string
+		want    cacheRule
+		wantErr bool
+	}{
+		{"*.html=no-cache", cacheRule{"*.html", "no-cache"}, false},
+		{"assets/*=public, max-age=60", cacheRule{"assets/*", "public, max-age=60"}, false},
+		{"no-cache", cacheRule{}, true},
+		{"=no-cache", cacheRule{}, true},
+		{"*.html=", cacheRule{}, true},
+		{"[=no-cache", cacheRule{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCacheRule(tt.flag)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCacheRule(%q) = %v, %v", tt.flag, got, err)
+		}
+	}
+}
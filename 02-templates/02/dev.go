@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// liveReloadPath is the server sent events endpoint pages listen to in dev mode
+const liveReloadPath = "/_livereload"
+
+// liveReloadScript is injected into every page served in dev mode, it reloads the page when the site changes
+const liveReloadScript = `<script>new EventSource("` + liveReloadPath + `").addEventListener("reload", function () { location.reload() })</script>`
+
+// devServer re-parses the site whenever its files change and tells open pages to reload
+type devServer struct {
+	dir string
+
+	mu  sync.RWMutex
+	rd  *renderer
+	err error // of the last parse, shown instead of pages until fixed
+
+	clientsMu sync.Mutex
+	clients   map[chan struct{}]bool
+}
+
+// newDevServer parses the site, parse errors don't stop it and are shown in the browser
+func newDevServer(dir string) *devServer {
+	d := &devServer{dir: dir, clients: make(map[chan struct{}]bool)}
+	d.load()
+	return d
+}
+
+// load parses the site again keeping the previous renderer if it fails
+func (d *devServer) load() {
+	rd, err := newRenderer(d.dir)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.err = err
+	if err == nil {
+		d.rd = rd
+	}
+}
+
+// current returns the renderer and the last parse error
+func (d *devServer) current() (*renderer, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rd, d.err
+}
+
+// watch polls the site directory every interval, reparses it and reloads pages on any change
+func (d *devServer) watch(interval time.Duration) {
+	last := fingerprint(d.dir)
+	for range time.Tick(interval) {
+		sum := fingerprint(d.dir)
+		if sum == last {
+			continue
+		}
+		last = sum
+		d.load()
+		if _, err := d.current(); err != nil {
+			log.Printf("reload: %s", err)
+		} else {
+			log.Printf("reload: site parsed")
+		}
+		d.broadcast()
+	}
+}
+
+// fingerprint hashes names, sizes and modification times of all files under dir
+func fingerprint(dir string) uint64 {
+	h := fnv.New64a()
+	filepath.WalkDir(dir, func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintln(h, file, err)
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintln(h, file, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return h.Sum64()
+}
+
+// broadcast tells every connected page to reload, clients that haven't picked up the previous event are skipped
+func (d *devServer) broadcast() {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	for ch := range d.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *devServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadPath {
+		d.events(w, r)
+		return
+	}
+	rd, err := d.current()
+	if err != nil {
+		errorPage(w, err)
+		return
+	}
+	name := pageName(r.URL.Path)
+	if _, ok := rd.pages[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var b bytes.Buffer
+	if err := rd.render(&b, name); err != nil {
+		errorPage(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(injectScript(b.Bytes()))
+}
+
+// injectScript puts live reload script before closing body tag or at the end of the page if there's none
+func injectScript(page []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, liveReloadScript...)
+	}
+	out := make([]byte, 0, len(page)+len(liveReloadScript))
+	out = append(out, page[:i]...)
+	out = append(out, liveReloadScript...)
+	return append(out, page[i:]...)
+}
+
+// errorTemplate shows template errors in the browser, the page reloads itself once the error is fixed
+var errorTemplate = template.Must(template.New("error").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>template error</title>
+<style>body { font-family: sans-serif; margin: 2em } pre { background: #fee; border-left: 4px solid #c00; padding: 1em; white-space: pre-wrap }</style>
+</head>
+<body>
+<h1>template error</h1>
+<pre>{{.Error}}</pre>
+<p>fix the file and save, this page reloads by itself</p>
+{{.Script}}
+</body>
+</html>
+`))
+
+// errorPage renders err as html page with live reload script
+func errorPage(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	errorTemplate.Execute(w, struct {
+		error
+		Script template.HTML
+	}{err, liveReloadScript})
+}
+
+// events streams reload events to a page until it goes away
+func (d *devServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "500 - streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan struct{}, 1)
+	d.clientsMu.Lock()
+	d.clients[ch] = true
+	d.clientsMu.Unlock()
+	defer func() {
+		d.clientsMu.Lock()
+		delete(d.clients, ch)
+		d.clientsMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// render site pages inside shared layout over http or into static files
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "site", "site root with layouts, partials, pages and site.json")
+	out := flag.String("out", "", "render all pages into this directory and exit, like public (empty serves over http)")
+	dev := flag.Bool("dev", false, "reparse site on file changes, reload open pages and show template errors in the browser")
+	flag.Parse()
+
+	static := http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(*dir, staticDir))))
+	http.Handle("/static/", static)
+	if *dev && *out == "" {
+		d := newDevServer(*dir)
+		if _, err := d.current(); err != nil {
+			log.Println(err)
+		}
+		go d.watch(500 * time.Millisecond)
+		http.Handle("/", d)
+		fmt.Println("Started dev server")
+		log.Fatal(http.ListenAndServe("localhost:8080", nil))
+	}
+
+	// production parses everything once and fails fast on template errors
+	rd, err := newRenderer(*dir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *out != "" {
+		if err := rd.build(*out); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	http.Handle("/", rd)
+	fmt.Println("Started server")
+	log.Fatal(http.ListenAndServe("localhost:8080", nil))
+}
+
+/*
+$ go run *.go
+Started server
+
+$ curl localhost:8080/
+<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Home - goweb</title>
+</head>
+<body>
+<nav>
+<a href="/">Home</a>
+<a href="/about">About</a>
+<a href="/blog/hello">Blog</a>
+</nav>
+<main>
+<h1>Welcome</h1>
+<p>2 posts so far</p>
+<ul>
+<li><a href="/blog/hello">Hello templates</a> Feb 20, 2021</li>
+<li><a href="/blog/hello#layouts">Layouts and partials</a> Feb 27, 2021</li>
+</ul>
+</main>
+<footer>goweb, rendered 2021-02-28 12:00</footer>
+</body>
+</html>
+
+$ curl -s -o /dev/null -w '%{http_code}\n' localhost:8080/missing
+404
+
+# static output for any file server
+$ go run *.go -out public
+2021/02/28 12:00:00 wrote public/about/index.html
+2021/02/28 12:00:00 wrote public/blog/hello/index.html
+2021/02/28 12:00:00 wrote public/index.html
+
+# dev mode reparses templates on save and reloads open pages through server sent events
+$ go run *.go -dev
+Started dev server
+$ curl -s localhost:8080/about | grep EventSource
+<script>new EventSource("/_livereload").addEventListener("reload", function () { location.reload() })</script></body>
+$ curl -N localhost:8080/_livereload
+: connected
+
+event: reload
+data: {}
+
+# broken templates are shown as error page until fixed
+$ curl -s localhost:8080/ | grep -A1 '<pre>'
+<pre>template: index.html:3: function &#34;pluralise&#34; not defined</pre>
+*/
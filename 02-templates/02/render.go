@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// site layout, every directory is relative to the site root
+const (
+	layoutsDir  = "layouts"  // base layouts, each defines a template named after its file
+	partialsDir = "partials" // shared snippets available to every layout and page
+	pagesDir    = "pages"    // pages define blocks of the layout, data comes from json or yaml file next to the page
+	staticDir   = "static"   // assets served under /static/ and copied as is
+	siteFile    = "site.json"
+	defaultBase = "base.html"
+)
+
+// dataExts are page data file extensions in order they are looked up
+var dataExts = []string{".json", ".yaml", ".yml"}
+
+// siteConfig is shared by all pages
+type siteConfig struct {
+	Title string     `json:"title"`
+	Menu  []menuItem `json:"menu"`
+}
+
+// menuItem is a single navigation link
+type menuItem struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// pageData is what page templates get as dot
+type pageData struct {
+	Site siteConfig
+	Page map[string]interface{} // page data file content, "layout" key selects the layout
+	Name string
+	Now  time.Time
+}
+
+// page is a parsed page together with its data
+type page struct {
+	tmpl   *template.Template
+	layout string
+	data   map[string]interface{}
+}
+
+// renderer renders pages of a site, everything is parsed once on creation
+type renderer struct {
+	dir   string
+	site  siteConfig
+	pages map[string]*page // by name, like "index" or "blog/hello"
+}
+
+// funcs are available in every template
+var funcs = template.FuncMap{
+	"date":      formatDate,
+	"pluralize": pluralize,
+	"safeURL":   safeURL,
+}
+
+// newRenderer parses layouts, partials and pages of the site in dir
+func newRenderer(dir string) (*renderer, error) {
+	rd := &renderer{dir: dir, pages: make(map[string]*page)}
+	if err := readData(filepath.Join(dir, siteFile), &rd.site); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	base := template.New("").Funcs(funcs)
+	for _, sub := range []string{layoutsDir, partialsDir} {
+		files, err := filepath.Glob(filepath.Join(dir, sub, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if base, err = base.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+
+	root := filepath.Join(dir, pagesDir)
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(file) != ".html" {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".html")
+
+		// every page gets its own copy of layouts so blocks of different pages don't clash
+		tmpl, err := template.Must(base.Clone()).ParseFiles(file)
+		if err != nil {
+			return err
+		}
+		p := &page{tmpl: tmpl, layout: defaultBase, data: make(map[string]interface{})}
+		if err := readPageData(strings.TrimSuffix(file, ".html"), &p.data); err != nil {
+			return fmt.Errorf("page %s: %s", name, err)
+		}
+		if layout, ok := p.data["layout"].(string); ok {
+			p.layout = layout
+		}
+		if tmpl.Lookup(p.layout) == nil {
+			return fmt.Errorf("page %s: no layout %q", name, p.layout)
+		}
+		rd.pages[name] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// readPageData reads the first existing data file of the page, pages without one get empty data
+func readPageData(base string, v interface{}) error {
+	for _, ext := range dataExts {
+		err := readData(base+ext, v)
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readData decodes json or yaml file depending on its extension
+func readData(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(file) == ".json" {
+		err = json.Unmarshal(data, v)
+	} else {
+		err = yaml.Unmarshal(data, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	return nil
+}
+
+// render executes layout of the named page to w
+func (rd *renderer) render(w io.Writer, name string) error {
+	p, ok := rd.pages[name]
+	if !ok {
+		return fmt.Errorf("no page %q", name)
+	}
+	return p.tmpl.ExecuteTemplate(w, p.layout, pageData{rd.site, p.data, name, time.Now()})
+}
+
+// names returns page names sorted
+func (rd *renderer) names() []string {
+	names := make([]string, 0, len(rd.pages))
+	for name := range rd.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pageName maps url path to page name, directories are served by their index page
+func pageName(urlPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if strings.HasSuffix(urlPath, "/") || name == "" {
+		name = path.Join(name, "index")
+	}
+	return strings.TrimSuffix(name, ".html")
+}
+
+// ServeHTTP renders the page of the url path, the page is buffered so template errors don't leave half written responses
+func (rd *renderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := pageName(r.URL.Path)
+	if _, ok := rd.pages[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var b bytes.Buffer
+	if err := rd.render(&b, name); err != nil {
+		log.Printf("render %s: %s", name, err)
+		http.Error(w, "500 - Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// outputPath is the file a page is written to, pages other than index get a directory so links work without .html
+func outputPath(name string) string {
+	if name == "index" || strings.HasSuffix(name, "/index") {
+		return filepath.FromSlash(name + ".html")
+	}
+	return filepath.FromSlash(name + "/index.html")
+}
+
+// build renders every page into out directory and copies static assets next to them
+func (rd *renderer) build(out string) error {
+	for _, name := range rd.names() {
+		var b bytes.Buffer
+		if err := rd.render(&b, name); err != nil {
+			return fmt.Errorf("render %s: %s", name, err)
+		}
+		file := filepath.Join(out, outputPath(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, b.Bytes(), 0644); err != nil {
+			return err
+		}
+		log.Printf("wrote %s", file)
+	}
+	return copyDir(filepath.Join(rd.dir, staticDir), filepath.Join(out, staticDir))
+}
+
+// copyDir copies files of src into dst, missing src is not an error
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && file == src {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+// dateLayouts are string date formats understood by date function
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// formatDate formats time.Time or a date string with layout, unknown values are returned as is
+func formatDate(value interface{}, layout string) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout)
+	case string:
+		for _, l := range dateLayouts {
+			if t, err := time.Parse(l, v); err == nil {
+				return t.Format(layout)
+			}
+		}
+		return v
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// pluralize picks singular form for exactly one, plural otherwise
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// safeURL trusts relative, http(s) and mailto urls so html/template doesn't escape them,
+// anything else is replaced the same way html/template does with unsafe urls
+func safeURL(s string) template.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "#ZgotmplZ"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return template.URL(s)
+	default:
+		return "#ZgotmplZ"
+	}
+}
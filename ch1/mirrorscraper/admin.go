@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// admin settings (set with command line flags)
+var (
+	adminToken    string
+	watchInterval time.Duration
+)
+
+func init() {
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token of admin api, empty disables it (default env "+adminTokenEnv+")")
+	flag.DurationVar(&watchInterval, "watch-interval", 5*time.Second, "how often mirror lists are checked for changes (0 disables)")
+}
+
+// adminTokenEnv holds the admin token when the flag isn't given, it's read after flag parsing
+// so the secret never shows up as the flag default in usage output
+const adminTokenEnv = "MIRRORSCRAPER_ADMIN_TOKEN"
+
+// disabledPrefix marks disabled mirrors in list files
+const disabledPrefix = "# disabled "
+
+// mirrorEntry is a single line of a list file. comments and blank lines are kept as entries
+// without url holding the line as it is, so edits through the admin api write them back.
+type mirrorEntry struct {
+	URL      string `json:"url"`
+	Disabled bool   `json:"disabled"`
+	Country  string `json:"country,omitempty"`
+	line     string // verbatim comment or blank line when URL is empty
+}
+
+// activeMirrors returns enabled mirrors of the profile, the slice must not be modified
+func (p *profile) activeMirrors() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.mirrors
+}
+
+// listEntries returns all mirrors of the profile including disabled ones, comment lines left out
+func (p *profile) listEntries() []mirrorEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]mirrorEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		if e.URL != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// reload reads the list file of the profile again, the old list is kept if the file can't be read
+func (p *profile) reload() error {
+	info, err := os.Stat(p.List)
+	if err != nil {
+		return err
+	}
+	var entries []mirrorEntry
+	if err := readList(p.List, &entries); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.setEntries(entries, info.ModTime())
+	p.mu.Unlock()
+	p.invalidate()
+	return nil
+}
+
+// setEntries replaces the list of the profile, p.mu must be held
+func (p *profile) setEntries(entries []mirrorEntry, modTime time.Time) {
+	mirrors := make([]string, 0, len(entries))
+	countries := make(map[string]string)
+	for _, e := range entries {
+		if e.URL == "" {
+			continue
+		}
+		if !e.Disabled {
+			mirrors = append(mirrors, e.URL)
+		}
+		if e.Country != "" {
+			countries[e.URL] = e.Country
+		}
+	}
+	p.entries = entries
+	p.mirrors = mirrors
+	p.countries = countries
+	p.modTime = modTime
+}
+
+// invalidate drops cached probe results so the next request sees the new list. a refresh still
+// probing the old list is left to its waiters, the next caller starts a new one.
+func (p *profile) invalidate() {
+	c := &p.cache
+	c.mu.Lock()
+	c.gen++
+	c.snap = snapshot{}
+	c.inflight = nil
+	c.mu.Unlock()
+}
+
+// update applies change to a copy of the list, writes it to the list file and only then makes it current
+func (p *profile) update(change func([]mirrorEntry) ([]mirrorEntry, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries, err := change(append([]mirrorEntry(nil), p.entries...))
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		if e.URL == "" {
+			fmt.Fprintln(&b, e.line)
+			continue
+		}
+		if e.Disabled {
+			b.WriteString(disabledPrefix)
+		}
+		if e.Country != "" {
+			fmt.Fprintln(&b, e.URL, e.Country)
+			continue
+		}
+		fmt.Fprintln(&b, e.URL)
+	}
+	if err := writeFileAtomic(p.List, []byte(b.String())); err != nil {
+		return err
+	}
+	info, err := os.Stat(p.List)
+	if err != nil {
+		return err
+	}
+	p.setEntries(entries, info.ModTime())
+	p.invalidate()
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it over path,
+// readers see either old or new content, never a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// watchLists reloads mirror lists on SIGHUP and whenever a list file modification time changes
+func watchLists(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+			for _, p := range profiles {
+				p.reloadLogged("SIGHUP")
+			}
+		case <-tick:
+			for _, p := range profiles {
+				info, err := os.Stat(p.List)
+				if err != nil {
+					continue
+				}
+				p.mu.RLock()
+				changed := !info.ModTime().Equal(p.modTime)
+				p.mu.RUnlock()
+				if changed {
+					p.reloadLogged("file change")
+				}
+			}
+		}
+	}
+}
+
+// reloadLogged reloads the profile and logs the outcome
+func (p *profile) reloadLogged(reason string) {
+	if err := p.reload(); err != nil {
+		log.Printf("reload %s on %s: %s", p.Name, reason, err)
+		return
+	}
+	log.Printf("reload %s on %s: %d mirrors enabled", p.Name, reason, len(p.activeMirrors()))
+}
+
+// mirrorChange is the json body of admin requests
+type mirrorChange struct {
+	URL      string `json:"url"`
+	Disabled bool   `json:"disabled"`
+	Country  string `json:"country"` // empty keeps the current one
+}
+
+// adminMirrorsHandler lists (GET), adds (POST), disables or enables (PATCH) and removes (DELETE) mirrors of a distro
+func adminMirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mirrorscraper admin"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{"admin token required"})
+		return
+	}
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, p.listEntries())
+		return
+	}
+
+	var change mirrorChange
+	switch r.Method {
+	case http.MethodDelete:
+		change.URL = r.URL.Query().Get("url")
+	case http.MethodPost, http.MethodPatch:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&change); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("bad json body: %s", err)})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, PATCH, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"405 - Method Not Allowed"})
+		return
+	}
+	mirror, err := normalizeMirror(change.URL)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	change.Country = strings.ToLower(change.Country)
+	if change.Country != "" && !countryCodeRe.MatchString(change.Country) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("country must be a two letter code, got %q", change.Country)})
+		return
+	}
+
+	status := http.StatusOK
+	err = p.update(func(entries []mirrorEntry) ([]mirrorEntry, error) {
+		for i, e := range entries {
+			if e.URL != mirror {
+				continue
+			}
+			switch r.Method {
+			case http.MethodPost:
+				status = http.StatusConflict
+				return nil, fmt.Errorf("mirror %s already exists", mirror)
+			case http.MethodPatch:
+				entries[i].Disabled = change.Disabled
+				if change.Country != "" {
+					entries[i].Country = change.Country
+				}
+				return entries, nil
+			default:
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		if r.Method == http.MethodPost {
+			status = http.StatusCreated
+			return append(entries, mirrorEntry{URL: mirror, Disabled: change.Disabled, Country: change.Country}), nil
+		}
+		status = http.StatusNotFound
+		return nil, fmt.Errorf("mirror %s not found", mirror)
+	})
+	if err != nil {
+		if status < 400 {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	log.Printf("admin: %s %s in %s", r.Method, mirror, p.Name)
+	writeJSON(w, status, p.listEntries())
+}
+
+// authorized checks bearer token of admin requests, admin api is closed when no token is configured
+func authorized(r *http.Request) bool {
+	const scheme = "Bearer "
+	h := r.Header.Get("Authorization")
+	if adminToken == "" || len(h) < len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(h[len(scheme):]), []byte(adminToken)) == 1
+}
+
+// normalizeMirror checks the mirror is an http(s) url and makes sure it ends with a slash
+func normalizeMirror(mirror string) (string, error) {
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || strings.ContainsAny(mirror, " \t\r\n") {
+		return "", fmt.Errorf("url must be an http(s) mirror root, got %q", mirror)
+	}
+	if !strings.HasSuffix(mirror, "/") {
+		mirror += "/"
+	}
+	return mirror, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthorized(t *testing.T) {
+	defer func(token string) { adminToken = token }(adminToken)
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   bool
+	}{
+		{"bearer token", "s3cret", "Bearer s3cret", true},
+		{"scheme is case insensitive", "s3cret", "bearer s3cret", true},
+		{"bare token", "s3cret", "s3cret", false},
+		{"other scheme", "s3cret", "Basic s3cret", false},
+		{"wrong token", "s3cret", "Bearer s3cre", false},
+		{"no header", "s3cret", "", false},
+		{"admin api disabled", "", "Bearer ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminToken = tt.token
+			r := httptest.NewRequest(http.MethodGet, "/admin/mirrors", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := authorized(r); got != tt.want {
+				t.Errorf("authorized = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsComments(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "mirrors.list")
+	original := "# debian mirrors, maintained by hand\n" +
+		"http://ftp.am.debian.org/debian/\n" +
+		"\n" +
+		"# baltics\n" +
+		"http://ftp.lt.debian.org/debian/ lt\n" +
+		"# disabled http://ftp.lv.debian.org/debian/\n"
+	if err := os.WriteFile(list, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &profile{Name: "test", List: list}
+	if err := p.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(p.listEntries()); got != 3 {
+		t.Fatalf("listEntries returned %d mirrors, want 3", got)
+	}
+	if got := p.activeMirrors(); len(got) != 2 {
+		t.Fatalf("active mirrors = %v, want 2", got)
+	}
+
+	err := p.update(func(entries []mirrorEntry) ([]mirrorEntry, error) {
+		for i, e := range entries {
+			if e.URL == "http://ftp.am.debian.org/debian/" {
+				entries[i].Disabled = true
+			}
+		}
+		return append(entries, mirrorEntry{URL: "http://mirror.example.org/debian/"}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(original, "http://ftp.am", "# disabled http://ftp.am", 1) + "http://mirror.example.org/debian/\n"
+	if string(data) != want {
+		t.Errorf("list file after update:\n%s\nwant:\n%s", data, want)
+	}
+}
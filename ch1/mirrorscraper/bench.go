@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// probe modes selectable with "mode" query parameter
+const (
+	modeLatency    = "latency"
+	modeThroughput = "throughput"
+)
+
+// benchmark settings (set with command line flags)
+var (
+	benchPath  string
+	benchBytes int64
+	benchTime  time.Duration
+)
+
+func init() {
+	flag.StringVar(&benchPath, "bench-path", "dists/stable/Release", "artifact downloaded from each mirror in throughput mode (default profile)")
+	flag.Int64Var(&benchBytes, "bench-bytes", 4<<20, "max bytes downloaded from a single mirror in throughput mode")
+	flag.DurationVar(&benchTime, "bench-time", 10*time.Second, "max time spent downloading from a single mirror in throughput mode")
+}
+
+// throughput mirror responce struct, latency of the embedded fastest is time to first byte
+type throughput struct {
+	fastest
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	BytesPerSec float64       `json:"bytes_per_sec"`
+}
+
+// throughputHandler returns the mirror of the profile with the best sustained download speed
+func throughputHandler(w http.ResponseWriter, r *http.Request, p *profile) {
+	path := p.BenchPath
+	if q := r.URL.Query().Get("path"); q != "" {
+		path = q
+	}
+	if path == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("distro %q has no bench path, set path parameter", p.Name)})
+		return
+	}
+	if err := checkRelativePath(path); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	response, err := findBestThroughput(r.Context(), p, path)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// findBestThroughput downloads path from every mirror of the profile and returns the fastest one by bytes per second
+func findBestThroughput(ctx context.Context, p *profile, path string) (throughput, error) {
+	mirrors := p.activeMirrors()
+	results := make([]throughput, len(mirrors))
+	runPool(ctx, mirrors, func(i int, mirror string) {
+		result, err := benchmark(ctx, p.url(mirror, path), mirror)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println(err)
+			}
+			return
+		}
+		results[i] = result
+	})
+	if err := ctx.Err(); err != nil {
+		return throughput{}, err
+	}
+
+	var best throughput
+	for _, result := range results {
+		if result.BytesPerSec > best.BytesPerSec {
+			best = result
+		}
+	}
+	if best.FastestMirror == "" {
+		return throughput{}, errNoMirror
+	}
+	log.Printf("Got the best mirror: %s with throughput: %.0f B/s", best.FastestMirror, best.BytesPerSec)
+	return best, nil
+}
+
+// benchmark downloads target of the mirror until the body ends, benchBytes are read or benchTime passes.
+// speed is computed over body transfer only, time to first byte is reported as latency.
+func benchmark(ctx context.Context, target, mirror string) (throughput, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// headers get probeTimeout and the transfer benchTime, slow headers don't eat into the transfer
+	headerTimer := time.AfterFunc(probeTimeout, cancel)
+	resp, t, err := tracedGet(ctx, target)
+	if !headerTimer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return throughput{}, fmt.Errorf("get %s: no response headers within %s", target, probeTimeout)
+	}
+	if err != nil {
+		return throughput{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return throughput{}, fmt.Errorf("get %s: unexpected status %s", target, resp.Status)
+	}
+	headers := time.Now()
+
+	timer := time.AfterFunc(benchTime, cancel)
+	defer timer.Stop()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, benchBytes))
+	elapsed := time.Since(headers)
+	if err != nil && !(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) || n == 0 {
+		return throughput{}, fmt.Errorf("get %s: read %d bytes: %v", target, n, err)
+	}
+
+	return throughput{
+		fastest:     fastest{FastestMirror: mirror, Latency: t.Total, Timing: &t},
+		Bytes:       n,
+		Duration:    elapsed,
+		BytesPerSec: float64(n) / elapsed.Seconds(),
+	}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkPhases(t *testing.T) {
+	defer func(timeout, bench time.Duration) { probeTimeout, benchTime = timeout, bench }(probeTimeout, benchTime)
+	probeTimeout, benchTime = 200*time.Millisecond, 200*time.Millisecond
+
+	tests := []struct {
+		name        string
+		headerDelay time.Duration
+		wantErr     string
+	}{
+		{"fast headers", 0, ""},
+		// headers within probeTimeout still leave the whole benchTime to the transfer
+		{"slow headers", 100 * time.Millisecond, ""},
+		{"headers too late", 400 * time.Millisecond, "no response headers within 200ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.headerDelay)
+				w.WriteHeader(http.StatusOK)
+				// trickle the body past benchTime, the transfer is cut at the time cap
+				chunk := make([]byte, 1024)
+				for i := 0; i < 30 && r.Context().Err() == nil; i++ {
+					w.Write(chunk)
+					w.(http.Flusher).Flush()
+					time.Sleep(10 * time.Millisecond)
+				}
+			}))
+			defer srv.Close()
+
+			got, err := benchmark(context.Background(), srv.URL+"/file", srv.URL)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Duration < benchTime-20*time.Millisecond || got.Bytes < 10*1024 {
+				t.Errorf("transfer took %s for %d bytes, want about %s", got.Duration, got.Bytes, benchTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// health checker settings (set with command line flags)
+var (
+	checkInterval time.Duration
+	cacheTTL      time.Duration
+)
+
+func init() {
+	flag.DurationVar(&checkInterval, "check-interval", time.Minute, "how often mirrors are re-probed in background (0 disables)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 5*time.Minute, "how long probe results are served from cache (0 probes on every request)")
+}
+
+// probeResult is the outcome of probing a single mirror
+type probeResult struct {
+	Mirror  string        `json:"mirror"`
+	Latency time.Duration `json:"latency"`
+	Timing  *timing       `json:"timing,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// snapshot holds probe results of all mirrors checked in one go, answered mirrors go first by latency.
+// stale mirrors are not probed and only listed in Rejected.
+type snapshot struct {
+	Results   []probeResult
+	Rejected  []rejection
+	CheckedAt time.Time
+}
+
+// cachedFastest responce struct tells how old the cached measurement is
+type cachedFastest struct {
+	fastest
+	Rejected  []rejection   `json:"rejected,omitempty"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Age       time.Duration `json:"age"`
+}
+
+// refreshResponse is returned by refresh endpoint
+type refreshResponse struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Mirrors   int       `json:"mirrors"`
+	Alive     int       `json:"alive"`
+	Rejected  int       `json:"rejected"`
+}
+
+// refreshCall is a refresh in progress, concurrent callers wait for it instead of probing again
+type refreshCall struct {
+	gen  uint64 // generation of the cache when the refresh started
+	done chan struct{}
+	snap snapshot
+	err  error
+}
+
+// resultCache keeps the latest snapshot of a profile and collapses concurrent refreshes into one.
+// gen changes whenever the mirror list does, refreshes started before that don't store their results.
+type resultCache struct {
+	mu       sync.Mutex
+	snap     snapshot
+	gen      uint64
+	inflight *refreshCall
+}
+
+// snapshot returns the latest probe results of the profile without probing
+func (p *profile) snapshot() snapshot {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+	return p.cache.snap
+}
+
+// fresh returns the latest snapshot if it is younger than ttl, otherwise refreshes it first
+func (p *profile) fresh(ctx context.Context, ttl time.Duration) (snapshot, error) {
+	if snap := p.snapshot(); !snap.CheckedAt.IsZero() && time.Since(snap.CheckedAt) < ttl {
+		return snap, nil
+	}
+	return p.refresh(ctx)
+}
+
+// refresh probes all mirrors of the profile and stores the results. if a refresh is already running the caller joins it.
+// probing is not bound to ctx since other callers may wait for it, ctx only limits the wait.
+func (p *profile) refresh(ctx context.Context) (snapshot, error) {
+	c := &p.cache
+	c.mu.Lock()
+	call := c.inflight
+	if call == nil {
+		call = &refreshCall{gen: c.gen, done: make(chan struct{})}
+		c.inflight = call
+		go p.runRefresh(call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.snap, call.err
+	case <-ctx.Done():
+		return snapshot{}, ctx.Err()
+	}
+}
+
+// runRefresh does the actual probing for the refresh call
+func (p *profile) runRefresh(call *refreshCall) {
+	ctx := context.Background()
+	fresh, rejected := checkFreshness(ctx, p)
+	snap := snapshot{Results: checkAll(ctx, p, fresh), Rejected: rejected, CheckedAt: time.Now()}
+
+	c := &p.cache
+	c.mu.Lock()
+	// a snapshot of an outdated list would bring removed mirrors back until the ttl runs out
+	if c.gen == call.gen {
+		c.snap = snap
+	}
+	if c.inflight == call {
+		c.inflight = nil
+	}
+	c.mu.Unlock()
+
+	call.snap = snap
+	close(call.done)
+}
+
+// fastest returns the quickest mirror that answered and is accepted, nil accept takes any mirror
+func (s snapshot) fastest(accept func(mirror string) bool) (fastest, error) {
+	for _, result := range s.Results {
+		if result.Error != "" {
+			break
+		}
+		if accept == nil || accept(result.Mirror) {
+			return fastest{FastestMirror: result.Mirror, Latency: result.Latency, Timing: result.Timing}, nil
+		}
+	}
+	return fastest{}, errNoMirror
+}
+
+// alive counts mirrors that answered
+func (s snapshot) alive() int {
+	n := 0
+	for _, result := range s.Results {
+		if result.Error == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkAll probes every mirror of the profile once and returns results sorted by latency, failed mirrors last
+func checkAll(ctx context.Context, p *profile, mirrors []string) []probeResult {
+	results := make([]probeResult, len(mirrors))
+	runPool(ctx, mirrors, func(i int, mirror string) {
+		results[i].Mirror = mirror
+		t, err := p.probe(ctx, mirror)
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Latency = t.Total
+		results[i].Timing = &t
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Error == "") != (results[j].Error == "") {
+			return results[i].Error == ""
+		}
+		return results[i].Latency < results[j].Latency
+	})
+	return results
+}
+
+// runChecker re-probes mirrors of the profile every interval for the lifetime of the program
+func (p *profile) runChecker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snap, err := p.refresh(context.Background())
+		if err != nil {
+			log.Printf("health check %s: %s", p.Name, err)
+		} else {
+			log.Printf("health check %s: %d of %d mirrors alive, %d rejected", p.Name, snap.alive(), len(snap.Results), len(snap.Rejected))
+		}
+		<-ticker.C
+	}
+}
+
+// cachedFastestHandler answers from the cache, refreshing it only when it's older than cacheTTL
+func cachedFastestHandler(w http.ResponseWriter, r *http.Request, p *profile, filter mirrorFilter) {
+	snap, err := p.fresh(r.Context(), cacheTTL)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	response, err := snap.fastest(func(mirror string) bool { return filter.allows(p, mirror) && filter.prefers(p, mirror) })
+	if err != nil {
+		response, err = snap.fastest(func(mirror string) bool { return filter.allows(p, mirror) })
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	response.Country = p.country(response.FastestMirror)
+	age := time.Since(snap.CheckedAt)
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	writeJSON(w, http.StatusOK, cachedFastest{response, snap.Rejected, snap.CheckedAt, age})
+}
+
+// refreshHandler re-probes all mirrors on demand, concurrent requests share a single refresh
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"use POST to refresh"})
+		return
+	}
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	snap, err := p.refresh(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, refreshResponse{snap.CheckedAt, len(snap.Results) + len(snap.Rejected), snap.alive(), len(snap.Rejected)})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshAfterInvalidate(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	defer slow.Close()
+	removed := slow.URL + "/debian/"
+	added := mirrorServer(t, http.StatusOK, 0)
+
+	p := &profile{Name: "test"}
+	setList := func(mirror string) {
+		p.mu.Lock()
+		p.setEntries([]mirrorEntry{{URL: mirror}}, time.Now())
+		p.mu.Unlock()
+		p.invalidate()
+	}
+	setList(removed)
+
+	// the refresh probing the old list is still running when the list changes
+	stale := make(chan snapshot, 1)
+	go func() {
+		snap, _ := p.refresh(context.Background())
+		stale <- snap
+	}()
+	<-arrived
+	setList(added)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	snap, err := p.refresh(ctx)
+	if err != nil {
+		t.Fatalf("refresh after invalidate waited for the old one: %s", err)
+	}
+	if len(snap.Results) != 1 || snap.Results[0].Mirror != added {
+		t.Fatalf("refresh after invalidate returned %+v, want the new list", snap.Results)
+	}
+
+	close(release)
+	if old := <-stale; len(old.Results) != 1 || old.Results[0].Mirror != removed {
+		t.Fatalf("old refresh returned %+v", old.Results)
+	}
+	if got := p.snapshot(); len(got.Results) != 1 || got.Results[0].Mirror != added {
+		t.Errorf("cached snapshot = %+v, the old refresh wrote back the removed mirror", got.Results)
+	}
+}
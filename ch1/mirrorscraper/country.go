@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// countryCodeRe matches lowercase two letter country codes
+var countryCodeRe = regexp.MustCompile(`^[a-z]{2}$`)
+
+// vanityTLDs are two letter top level domains commonly used without any relation to the country
+var vanityTLDs = map[string]bool{"io": true, "co": true, "me": true, "tv": true, "cc": true, "ai": true}
+
+// mirrorHost returns host of the mirror url, port included if the url has one
+func mirrorHost(mirror string) string {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return mirror
+	}
+	return u.Host
+}
+
+// mirrorCountry guesses lowercase ISO country code out of the mirror host name,
+// like by in ftp.by.debian.org, de in de.archive.ubuntu.com or ru in mirror.yandex.ru,
+// and returns empty string if there's none
+func mirrorCountry(mirror string) string {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(labels) == 4 && labels[0] == "ftp" && labels[2] == "debian" && countryCodeRe.MatchString(labels[1]):
+		return labels[1]
+	case len(labels) == 4 && labels[1] == "archive" && labels[2] == "ubuntu" && countryCodeRe.MatchString(labels[0]):
+		return labels[0]
+	}
+	tld := labels[len(labels)-1]
+	switch {
+	case tld == "uk":
+		return "gb"
+	case countryCodeRe.MatchString(tld) && !vanityTLDs[tld]:
+		return tld
+	}
+	return ""
+}
+
+// country returns country code of the mirror given in the list file or guessed from its host name
+func (p *profile) country(mirror string) string {
+	p.mu.RLock()
+	country, ok := p.countries[mirror]
+	p.mu.RUnlock()
+	if ok {
+		return country
+	}
+	return mirrorCountry(mirror)
+}
+
+// mirrorFilter narrows mirror selection down by country and host
+type mirrorFilter struct {
+	countries map[string]bool // only mirrors from these countries, any country if empty
+	excluded  map[string]bool // country codes and hosts never selected
+	preferred map[string]bool // countries tried before the others
+}
+
+// parseMirrorFilter reads comma separated country, exclude and prefer query parameters.
+// exclude takes both country codes and mirror hosts.
+func parseMirrorFilter(q url.Values) (mirrorFilter, error) {
+	var f mirrorFilter
+	var err error
+	if f.countries, err = parseList(q.Get("country"), true); err != nil {
+		return f, err
+	}
+	if f.excluded, err = parseList(q.Get("exclude"), false); err != nil {
+		return f, err
+	}
+	if f.preferred, err = parseList(q.Get("prefer"), true); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// parseList splits comma separated values into a set, with codesOnly every value must be a country code
+func parseList(value string, codesOnly bool) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(strings.ToLower(value), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if codesOnly && !countryCodeRe.MatchString(item) {
+			return nil, fmt.Errorf("%q is not a two letter country code", item)
+		}
+		set[item] = true
+	}
+	return set, nil
+}
+
+// allows reports whether the mirror passes country and exclude filters
+func (f mirrorFilter) allows(p *profile, mirror string) bool {
+	country := p.country(mirror)
+	if len(f.countries) > 0 && !f.countries[country] {
+		return false
+	}
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return false
+	}
+	return !f.excluded[country] && !f.excluded[strings.ToLower(u.Host)] && !f.excluded[strings.ToLower(u.Hostname())]
+}
+
+// prefers reports whether the mirror is from a preferred country, all mirrors are when nothing is preferred
+func (f mirrorFilter) prefers(p *profile, mirror string) bool {
+	return len(f.preferred) == 0 || f.preferred[p.country(mirror)]
+}
+
+// split drops mirrors not allowed by the filter and separates preferred ones from the rest
+func (f mirrorFilter) split(p *profile, mirrors []string) (preferred, others []string) {
+	for _, mirror := range mirrors {
+		switch {
+		case !f.allows(p, mirror):
+		case f.prefers(p, mirror):
+			preferred = append(preferred, mirror)
+		default:
+			others = append(others, mirror)
+		}
+	}
+	return preferred, others
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// history settings (set with command line flags)
+var (
+	historyPath      string
+	historyRetention time.Duration
+)
+
+func init() {
+	flag.StringVar(&historyPath, "history-db", "mirrors.db", "sqlite database of probe results (empty disables)")
+	flag.DurationVar(&historyRetention, "history-retention", 30*24*time.Hour, "how long probe results are kept")
+}
+
+const (
+	// probeTable create sql statement, TS and LATENCY are nanoseconds
+	probeTable = `
+	CREATE TABLE IF NOT EXISTS probe (
+           ID INTEGER PRIMARY KEY AUTOINCREMENT,
+           DISTRO VARCHAR(64) NOT NULL,
+           MIRROR VARCHAR(255) NOT NULL,
+           TS INTEGER NOT NULL,
+           LATENCY INTEGER NOT NULL,
+           ERROR TEXT NULL
+        )
+	`
+	// probeIndex speeds up per mirror time range queries
+	probeIndex = `CREATE INDEX IF NOT EXISTS probe_distro_mirror_ts ON probe (DISTRO, MIRROR, TS)`
+
+	// history query defaults and limits
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 10000
+	maxTrendPoints      = 1000
+	defaultTrendSteps   = 4 // points per window unless step is given
+)
+
+// probeRecord is a single stored probe result
+type probeRecord struct {
+	distro  string
+	Mirror  string        `json:"mirror"`
+	Time    time.Time     `json:"time"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// mirrorTrend responce struct summarizes probes of a mirror in a time range
+type mirrorTrend struct {
+	Mirror     string        `json:"mirror"`
+	Probes     int           `json:"probes"`
+	Uptime     float64       `json:"uptime"` // percent of successful probes
+	AvgLatency time.Duration `json:"avg_latency"`
+	Series     []trendPoint  `json:"series"`
+}
+
+// trendPoint summarizes probes of a mirror within the window ending at End, points are step apart
+// so consecutive windows overlap and form a moving average
+type trendPoint struct {
+	End        time.Time     `json:"end"`
+	Probes     int           `json:"probes"`
+	Uptime     float64       `json:"uptime"`
+	AvgLatency time.Duration `json:"avg_latency"`
+}
+
+// probeBucket sums up probes of a mirror within one step
+type probeBucket struct {
+	probes, ok int
+	latency    float64 // sum over successful probes
+}
+
+// historyStore writes probe results to sqlite from a single goroutine so probes never wait for the disk
+type historyStore struct {
+	db      *sql.DB
+	records chan probeRecord
+}
+
+// history is nil when history is disabled
+var history *historyStore
+
+// openHistory opens or creates the database and starts the writer
+func openHistory(path string) (*historyStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{probeTable, probeIndex} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	h := &historyStore{db: db, records: make(chan probeRecord, 1024)}
+	go h.run()
+	return h, nil
+}
+
+// record queues a probe result, results are dropped when the writer can't keep up
+func (h *historyStore) record(distro, mirror string, latency time.Duration, err error) {
+	if h == nil {
+		return
+	}
+	rec := probeRecord{distro: distro, Mirror: mirror, Time: time.Now(), Latency: latency}
+	if err != nil {
+		rec.Latency = 0
+		rec.Error = err.Error()
+	}
+	select {
+	case h.records <- rec:
+	default:
+		log.Printf("history: queue full, dropped probe of %s", mirror)
+	}
+}
+
+// run writes queued records in batches and prunes records older than retention every hour
+func (h *historyStore) run() {
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	var batch []probeRecord
+	for {
+		select {
+		case rec := <-h.records:
+			batch = append(batch, rec)
+			if len(batch) < 100 {
+				continue
+			}
+		case <-flush.C:
+		case <-prune.C:
+			cutoff := time.Now().Add(-historyRetention).UnixNano()
+			if _, err := h.db.Exec("DELETE FROM probe WHERE TS < ?", cutoff); err != nil {
+				log.Printf("history: prune: %s", err)
+			}
+			continue
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := h.insert(batch); err != nil {
+			log.Printf("history: lost %d probes: %s", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// insert stores records in one transaction
+func (h *historyStore) insert(records []probeRecord) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	statement, err := tx.Prepare("INSERT INTO probe (DISTRO, MIRROR, TS, LATENCY, ERROR) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer statement.Close()
+	for _, rec := range records {
+		var errText sql.NullString
+		if rec.Error != "" {
+			errText = sql.NullString{String: rec.Error, Valid: true}
+		}
+		if _, err := statement.Exec(rec.distro, rec.Mirror, rec.Time.UnixNano(), int64(rec.Latency), errText); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// query returns newest probes of the mirror (all mirrors if empty) since given time
+func (h *historyStore) query(distro, mirror string, since time.Time, limit int) ([]probeRecord, error) {
+	rows, err := h.db.Query(`SELECT MIRROR, TS, LATENCY, ERROR FROM probe
+		WHERE DISTRO = ? AND (? = '' OR MIRROR = ?) AND TS >= ?
+		ORDER BY TS DESC LIMIT ?`, distro, mirror, mirror, since.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []probeRecord{}
+	for rows.Next() {
+		var rec probeRecord
+		var ts, latency int64
+		var errText sql.NullString
+		if err := rows.Scan(&rec.Mirror, &ts, &latency, &errText); err != nil {
+			return nil, err
+		}
+		rec.Time = time.Unix(0, ts)
+		rec.Latency = time.Duration(latency)
+		rec.Error = errText.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// trend summarizes probes of the mirror (all mirrors if empty) since given time, both overall and as moving
+// average over window taken every step. window must be a multiple of step. latency averages only count
+// successful probes.
+func (h *historyStore) trend(distro, mirror string, since time.Time, window, step time.Duration) ([]mirrorTrend, error) {
+	// probes are summed up per step in sql, windows are put together from steps below.
+	// the first window reaches back before since so all points cover a whole window.
+	from := since.Add(step - window)
+	rows, err := h.db.Query(`SELECT MIRROR, (TS - ?) / ?, COUNT(*), SUM(ERROR IS NULL), TOTAL(CASE WHEN ERROR IS NULL THEN LATENCY END)
+		FROM probe WHERE DISTRO = ? AND (? = '' OR MIRROR = ?) AND TS >= ?
+		GROUP BY 1, 2 ORDER BY 1, 2`,
+		from.UnixNano(), int64(step), distro, mirror, mirror, from.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	buckets := make(map[string]map[int64]probeBucket)
+	for rows.Next() {
+		var name string
+		var index int64
+		var b probeBucket
+		if err := rows.Scan(&name, &index, &b.probes, &b.ok, &b.latency); err != nil {
+			return nil, err
+		}
+		if buckets[name] == nil {
+			names = append(names, name)
+			buckets[name] = make(map[int64]probeBucket)
+		}
+		buckets[name][index] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	steps := int64(window / step)
+	trends := make([]mirrorTrend, 0, len(names))
+	for _, name := range names {
+		var last int64
+		for index := range buckets[name] {
+			if index > last {
+				last = index
+			}
+		}
+		t := mirrorTrend{Mirror: name, Series: []trendPoint{}}
+		var total, sliding probeBucket
+		for index := int64(0); index <= last; index++ {
+			b := buckets[name][index]
+			sliding.add(b, 1)
+			if index < steps-1 {
+				continue // before since, only fills the first window
+			}
+			sliding.add(buckets[name][index-steps], -1)
+			total.add(b, 1)
+			if sliding.probes > 0 {
+				t.Series = append(t.Series, trendPoint{
+					End:        from.Add(time.Duration(index+1) * step),
+					Probes:     sliding.probes,
+					Uptime:     percent(sliding.ok, sliding.probes),
+					AvgLatency: sliding.avg(),
+				})
+			}
+		}
+		if total.probes == 0 {
+			continue // probed only before since
+		}
+		t.Probes = total.probes
+		t.Uptime = percent(total.ok, total.probes)
+		t.AvgLatency = total.avg()
+		trends = append(trends, t)
+	}
+	return trends, nil
+}
+
+// add adds probes of b to the sum, sign -1 takes them away
+func (s *probeBucket) add(b probeBucket, sign int) {
+	s.probes += sign * b.probes
+	s.ok += sign * b.ok
+	s.latency += float64(sign) * b.latency
+}
+
+// avg returns average latency of successful probes
+func (s probeBucket) avg() time.Duration {
+	if s.ok == 0 {
+		return 0
+	}
+	return time.Duration(s.latency / float64(s.ok))
+}
+
+// percent returns part of total in percent rounded to two decimals
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*10000/float64(total)) / 100
+}
+
+// durationParam parses optional duration query parameter and checks it is within [min, max]
+func durationParam(r *http.Request, name string, def, min, max time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < min || d > max {
+		return 0, fmt.Errorf("%s must be a duration between %s and %s", name, min, max)
+	}
+	return d, nil
+}
+
+// historyHandler returns stored probes of a mirror, newest first
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{"history is disabled"})
+		return
+	}
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	since, err := durationParam(r, "since", 24*time.Hour, time.Second, historyRetention)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	limit, err := intParam(r, "limit", defaultHistoryLimit, 1, maxHistoryLimit)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	records, err := history.query(p.Name, r.URL.Query().Get("mirror"), time.Now().Add(-since), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// trendHandler returns uptime and average latency of mirrors overall and as moving average over window
+func trendHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{"history is disabled"})
+		return
+	}
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	since, err := durationParam(r, "since", 24*time.Hour, time.Minute, historyRetention)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	window, err := durationParam(r, "window", time.Hour, time.Minute, since)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	step, err := durationParam(r, "step", window/defaultTrendSteps, time.Second, window)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	if window%step != 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"window must be a multiple of step"})
+		return
+	}
+	if since/step > maxTrendPoints {
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("since/step must not exceed %d points", maxTrendPoints)})
+		return
+	}
+
+	// points are aligned to the step so repeated calls return comparable points
+	start := time.Now().Add(-since).Truncate(step)
+	trends, err := history.trend(p.Name, r.URL.Query().Get("mirror"), start, window, step)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, trends)
+}
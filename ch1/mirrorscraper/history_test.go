@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	mirrorA = "http://ftp.a.debian.org/debian/"
+	mirrorB = "http://ftp.b.debian.org/debian/"
+)
+
+// testHistory opens a history database in a temporary directory filled with records
+func testHistory(t *testing.T, records []probeRecord) *historyStore {
+	h, err := openHistory(filepath.Join(t.TempDir(), "mirrors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.db.Close() })
+	if err := h.insert(records); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// probeAt is a record of the test distro, a failed probe if latency is zero
+func probeAt(mirror string, at time.Time, latency time.Duration) probeRecord {
+	rec := probeRecord{distro: "test", Mirror: mirror, Time: at, Latency: latency}
+	if latency == 0 {
+		rec.Error = "connection refused"
+	}
+	return rec
+}
+
+func TestHistoryQuery(t *testing.T) {
+	now := time.Now()
+	h := testHistory(t, []probeRecord{
+		probeAt(mirrorA, now.Add(-3*time.Hour), 10*time.Millisecond),
+		probeAt(mirrorA, now.Add(-2*time.Minute), 20*time.Millisecond),
+		probeAt(mirrorA, now.Add(-time.Minute), 0),
+		probeAt(mirrorB, now.Add(-time.Minute), 30*time.Millisecond),
+		{distro: "other", Mirror: mirrorA, Time: now, Latency: time.Millisecond},
+	})
+	tests := []struct {
+		name   string
+		mirror string
+		since  time.Duration
+		limit  int
+		want   []time.Duration // latencies newest first
+	}{
+		{"one mirror", mirrorA, time.Hour, 10, []time.Duration{0, 20 * time.Millisecond}},
+		{"older probes", mirrorA, 4 * time.Hour, 10, []time.Duration{0, 20 * time.Millisecond, 10 * time.Millisecond}},
+		{"limit", mirrorA, 4 * time.Hour, 1, []time.Duration{0}},
+		{"all mirrors", "", time.Hour, 10, []time.Duration{0, 30 * time.Millisecond, 20 * time.Millisecond}},
+		{"unknown mirror", "http://nowhere/", time.Hour, 10, []time.Duration{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := h.query("test", tt.mirror, now.Add(-tt.since), tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []time.Duration{}
+			for _, rec := range records {
+				got = append(got, rec.Latency)
+				if (rec.Latency == 0) != (rec.Error != "") {
+					t.Errorf("record %+v: error and latency disagree", rec)
+				}
+			}
+			for i := 1; i < len(records); i++ {
+				if records[i].Time.After(records[i-1].Time) {
+					t.Errorf("records aren't newest first: %+v", records)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latencies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryTrend(t *testing.T) {
+	since := time.Date(2021, 2, 16, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return since.Add(d) }
+	h := testHistory(t, []probeRecord{
+		// before since, counted in the first window only
+		probeAt(mirrorA, at(-20*time.Minute), 100*time.Millisecond),
+		probeAt(mirrorA, at(10*time.Minute), 200*time.Millisecond),
+		probeAt(mirrorA, at(40*time.Minute), 0),
+		probeAt(mirrorA, at(70*time.Minute), 400*time.Millisecond),
+		// probed before since only, left out
+		probeAt(mirrorB, at(-20*time.Minute), 100*time.Millisecond),
+	})
+
+	trends, err := h.trend("test", "", since, time.Hour, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mirrorTrend{{
+		Mirror:     mirrorA,
+		Probes:     3,
+		Uptime:     66.67,
+		AvgLatency: 300 * time.Millisecond,
+		Series: []trendPoint{
+			// each point averages the hour before it, points are half an hour apart
+			{End: at(30 * time.Minute), Probes: 2, Uptime: 100, AvgLatency: 150 * time.Millisecond},
+			{End: at(60 * time.Minute), Probes: 2, Uptime: 50, AvgLatency: 200 * time.Millisecond},
+			{End: at(90 * time.Minute), Probes: 2, Uptime: 50, AvgLatency: 400 * time.Millisecond},
+		},
+	}}
+	for i := range trends {
+		for j := range trends[i].Series {
+			trends[i].Series[j].End = trends[i].Series[j].End.UTC()
+		}
+	}
+	if !reflect.DeepEqual(trends, want) {
+		t.Errorf("trend =\n%+v\nwant\n%+v", trends, want)
+	}
+
+	// a window as long as the step gives plain per step averages, gaps have no points
+	trends, err = h.trend("test", mirrorA, since, 30*time.Minute, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trends) != 1 || len(trends[0].Series) != 3 || trends[0].Series[1].Uptime != 0 {
+		t.Errorf("trend with window = step: %+v", trends)
+	}
+}
+
+func TestHistoryHandlers(t *testing.T) {
+	defer func(h *historyStore) { history = h }(history)
+	profiles["test"] = &profile{Name: "test"}
+	defer delete(profiles, "test")
+
+	now := time.Now()
+	history = testHistory(t, []probeRecord{
+		probeAt(mirrorA, now.Add(-2*time.Minute), 20*time.Millisecond),
+		probeAt(mirrorA, now.Add(-time.Minute), 0),
+	})
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		status  int
+		records int // length of the json array answered
+	}{
+		{"history", historyHandler, "/history?distro=test&since=1h", http.StatusOK, 2},
+		{"history limit", historyHandler, "/history?distro=test&limit=1", http.StatusOK, 1},
+		{"history of a mirror", historyHandler, "/history?distro=test&mirror=" + mirrorB, http.StatusOK, 0},
+		{"history bad since", historyHandler, "/history?distro=test&since=forever", http.StatusBadRequest, -1},
+		{"history bad limit", historyHandler, "/history?distro=test&limit=0", http.StatusBadRequest, -1},
+		{"history unknown distro", historyHandler, "/history?distro=nope", http.StatusNotFound, -1},
+		{"trend", trendHandler, "/trend?distro=test&since=1h&window=10m&step=5m", http.StatusOK, 1},
+		{"trend default step", trendHandler, "/trend?distro=test&since=1h&window=20m", http.StatusOK, 1},
+		{"trend step not dividing window", trendHandler, "/trend?distro=test&window=1h&step=7m", http.StatusBadRequest, -1},
+		{"trend step over window", trendHandler, "/trend?distro=test&window=10m&step=20m", http.StatusBadRequest, -1},
+		{"trend too many points", trendHandler, "/trend?distro=test&since=24h&window=1m&step=1s", http.StatusBadRequest, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.records < 0 {
+				return
+			}
+			var list []json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != tt.records {
+				t.Errorf("got %d records, want %d: %s", len(list), tt.records, rec.Body)
+			}
+		})
+	}
+
+	history = nil
+	rec := httptest.NewRecorder()
+	trendHandler(rec, httptest.NewRequest(http.MethodGet, "/trend", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("trend with history disabled: status = %d, want 404", rec.Code)
+	}
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,55 +19,184 @@ import (
 type fastest struct {
 	FastestMirror string        `json:"fastest_mirror"`
 	Latency       time.Duration `json:"latency"`
+	Timing        *timing       `json:"timing,omitempty"`
+	Country       string        `json:"country,omitempty"`
 }
 
-// globally scoped mirrors slice var
-var mirrors []string
+// freshFastest responce struct lists mirrors excluded for serving stale data
+type freshFastest struct {
+	fastest
+	Rejected []rejection `json:"rejected,omitempty"`
+}
+
+// errorResponse is returned as json body when request could not be served
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// errNoMirror is returned when none of the probed mirrors answered
+var errNoMirror = errors.New("no mirror answered")
+
+// settings (set with command line flags)
+var (
+	listPath     string
+	workers      int
+	probeTimeout time.Duration
+)
 
-// read a list of mirrors into a slice
 func init() {
-	if err := readList("mirrors.list", &mirrors); err != nil {
-		log.Fatalf("readList: %s", err)
-	}
+	flag.StringVar(&listPath, "list", "mirrors.list", "file with mirror urls, one per line (default profile)")
+	flag.IntVar(&workers, "workers", 10, "max number of mirrors probed concurrently")
+	flag.DurationVar(&probeTimeout, "timeout", 5*time.Second, "deadline for a single mirror probe")
 }
 
 // findFastestHandler returns fastest mirror and latency struct
 func findFastestHandler(w http.ResponseWriter, r *http.Request) {
-	response := findFastest(&mirrors)
-	respJSON, _ := json.Marshal(response)
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", modeLatency:
+	case modeThroughput:
+		throughputHandler(w, r, p)
+		return
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{fmt.Sprintf("unknown mode %q", mode)})
+		return
+	}
+	filter, err := parseMirrorFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	if cacheTTL > 0 {
+		cachedFastestHandler(w, r, p, filter)
+		return
+	}
+
+	// preferred mirrors get the first chance, the rest are probed only if none of them answered
+	fresh, rejected := checkFreshness(r.Context(), p)
+	preferred, others := filter.split(p, fresh)
+	response, err := findFastest(r.Context(), p, preferred)
+	if errors.Is(err, errNoMirror) {
+		response, err = findFastest(r.Context(), p, others)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, freshFastest{response, rejected})
+}
+
+// writeJSON marshals v and writes it to the response with given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	respJSON, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(respJSON)
 }
 
-// findFastest reads the mirrors slice and returns fastest and latency
-func findFastest(mirrors *[]string) fastest {
-	mirrorChan := make(chan string)
-	latencyChan := make(chan time.Duration)
-
-	for _, mirror := range *mirrors {
-		go func(mirror string) {
-			// log.Println("Started probing: ", mirror)
-			start := time.Now()
-			_, err := http.Get(mirror)
-			latency := time.Now().Sub(start)
+// findFastest probes the mirrors of the profile with a pool of workers and returns the first one to answer.
+// in-flight probes are canceled as soon as the winner is known or ctx is done.
+func findFastest(ctx context.Context, p *profile, mirrors []string) (fastest, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan fastest, 1) // buffered so the winner never blocks
+	go func() {
+		runPool(ctx, mirrors, func(_ int, mirror string) {
+			t, err := p.probe(ctx, mirror)
 			if err != nil {
-				log.Println(err)
+				if ctx.Err() == nil {
+					log.Println(err)
+				}
 				return
 			}
+			// only the first result is kept, losers are dropped
+			select {
+			case results <- fastest{mirror, t.Total, &t, p.country(mirror)}:
+				log.Printf("Got the best mirror: %s with latency: %s", mirror, t.Total)
+				cancel()
+			default:
+			}
+		})
+		// all workers are done, nobody will send anymore
+		close(results)
+	}()
 
-			// send results to channel canceling all other goroutines when function returns
-			mirrorChan <- mirror
-			latencyChan <- latency
+	result, ok := <-results
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return fastest{}, err
+		}
+		return fastest{}, errNoMirror
+	}
+	return result, nil
+}
+
+// runPool calls fn for every mirror from at most workers goroutines and waits for them to finish.
+// mirrors not yet started when ctx is done are skipped.
+func runPool(ctx context.Context, mirrors []string, fn func(i int, mirror string)) {
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range mirrors {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-			log.Printf("Got the best mirror: %s with latency: %s", mirror, latency)
-		}(mirror)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i, mirrors[i])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe requests probe path of the mirror and returns time spent until response headers arrived broken down by phase
+func (p *profile) probe(ctx context.Context, mirror string) (timing, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	target := p.url(mirror, p.ProbePath)
+	resp, t, err := tracedGet(probeCtx, target)
+	if err == nil {
+		resp.Body.Close()
+		// a mirror answering with an error page isn't serving the distro
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("get %s: unexpected status %s", target, resp.Status)
+		}
+	}
+	// probes canceled by the caller say nothing about the mirror
+	if ctx.Err() == nil {
+		history.record(p.Name, mirror, t.Total, err)
+		metrics.observe(p.Name, mirror, p.country(mirror), t.Total, err)
+	}
+	if err != nil {
+		return timing{}, err
 	}
 
-	return fastest{<-mirrorChan, <-latencyChan}
+	return t, nil
 }
 
-// readList reads the file into a mirrors slice
-func readList(path string, list *[]string) error {
+// readList reads the file into a mirrors slice, url may be followed by country code of the mirror.
+// "# disabled <url>" lines are kept as disabled mirrors, other comments and blank lines as entries without url.
+func readList(path string, list *[]mirrorEntry) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -71,20 +205,202 @@ func readList(path string, list *[]string) error {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		*list = append(*list, scanner.Text())
+		line := strings.TrimSpace(scanner.Text())
+		disabled := strings.HasPrefix(line, disabledPrefix)
+		if disabled {
+			line = strings.TrimPrefix(line, disabledPrefix)
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0 || (!disabled && strings.HasPrefix(line, "#")):
+			*list = append(*list, mirrorEntry{line: scanner.Text()})
+		case len(fields) == 1:
+			*list = append(*list, mirrorEntry{URL: fields[0], Disabled: disabled})
+		default:
+			*list = append(*list, mirrorEntry{URL: fields[0], Disabled: disabled, Country: strings.ToLower(fields[1])})
+		}
 	}
 
 	return scanner.Err()
 }
 
 func main() {
-	// fmt.Println(mirrors)
+	// scrape subcommand refreshes mirrors.list and exits
+	if len(os.Args) > 1 && os.Args[1] == "scrape" {
+		if err := runScrape(os.Args[2:]); err != nil {
+			log.Fatalf("scrape: %s", err)
+		}
+		return
+	}
+
+	flag.Parse()
+	if adminToken == "" {
+		adminToken = os.Getenv(adminTokenEnv)
+	}
+	if workers < 1 {
+		log.Fatalf("workers must be positive, got %d", workers)
+	}
+	// read profiles and their lists of mirrors
+	if err := loadProfiles(profilesPath); err != nil {
+		log.Fatalf("loadProfiles: %s", err)
+	}
+	// sources cli mode prints apt sources and exits
+	if flag.Arg(0) == "sources" {
+		if err := runSources(context.Background(), os.Stdout, flag.Args()[1:]); err != nil {
+			log.Fatalf("sources: %s", err)
+		}
+		return
+	}
+	if historyPath != "" {
+		h, err := openHistory(historyPath)
+		if err != nil {
+			log.Fatalf("openHistory: %s", err)
+		}
+		history = h
+	}
+	if err := setupProxy(); err != nil {
+		log.Fatalf("setupProxy: %s", err)
+	}
 	fmt.Println("Starting server")
 	http.HandleFunc("/", findFastestHandler)
+	http.HandleFunc("/rank", rankHandler)
+	http.HandleFunc("/refresh", refreshHandler)
+	http.HandleFunc("/config", configHandler)
+	http.HandleFunc("/sources.list", sourcesHandler)
+	http.HandleFunc("/mirror/", proxyHandler)
+	http.HandleFunc("/admin/mirrors", adminMirrorsHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/trend", trendHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	go watchLists(watchInterval)
+	if cacheTTL > 0 && checkInterval > 0 {
+		for _, p := range profiles {
+			go p.runChecker(checkInterval)
+		}
+	}
 	log.Fatal(http.ListenAndServe("localhost:8080", nil))
 }
 
 /* USAGE:
+$ go run *.go scrape -o mirrors.list -json mirrors.json
+2021/02/16 12:50:02 Scraped 362 mirrors from https://www.debian.org/mirror/list
+
+# prefer https urls where mirrors support it, probes then include tls handshake timing
+$ go run *.go scrape -scheme https
+
+$ go run *.go -list mirrors.list -workers 5 -timeout 2s -check-interval 1m -cache-ttl 5m
+
+$ curl -i -w'\n' localhost:8080/
+HTTP/1.1 200 OK
+Age: 12
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 12:56:36 GMT
+Content-Length: 351
+
+{"fastest_mirror":"http://ftp.by.debian.org/debian/","latency":81780824,"timing":{"dns":1203311,"connect":39811205,"tls":0,"ttfb":40601533,"total":81780824},"rejected":[{"mirror":"http://ftp.am.debian.org/debian/","reason":"release is 26h10m0s behind 2021-02-06T10:18:42Z"}],"checked_at":"2021-02-16T14:56:24.11+02:00","age":12403117925}
+
+$ curl -i -w'\n' -X POST localhost:8080/refresh
+HTTP/1.1 200 OK
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 12:57:01 GMT
+Content-Length: 82
+
+{"checked_at":"2021-02-16T14:57:01.35+02:00","mirrors":48,"alive":45,"rejected":1}
+
+# profiles.json describes mirror lists, probe paths and freshness files of other distros
+$ go run *.go -profiles profiles.json -distro debian
+
+$ curl -i -w'\n' 'localhost:8080/?distro=alpine'
+HTTP/1.1 200 OK
+Age: 3
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 12:58:40 GMT
+Content-Length: 241
+
+{"fastest_mirror":"http://mirrors.dotsrc.org/alpine/","latency":42118004,"timing":{"dns":2215031,"connect":19203118,"tls":0,"ttfb":20601231,"total":42118004},"checked_at":"2021-02-16T14:58:37.02+02:00","age":3120448812}
+
+$ curl -i 'localhost:8080/config?distro=alpine'
+HTTP/1.1 200 OK
+Content-Type: text/plain; charset=utf-8
+Date: Tue, 16 Feb 2021 12:58:45 GMT
+Content-Length: 81
+
+http://mirrors.dotsrc.org/alpine/v3.20/main
+http://mirrors.dotsrc.org/alpine/v3.20/community
+
+$ curl -i 'localhost:8080/sources.list?suite=bookworm&components=main,contrib&top=2'
+HTTP/1.1 200 OK
+Content-Type: text/plain; charset=utf-8
+Date: Tue, 16 Feb 2021 12:59:02 GMT
+Content-Length: 354
+
+deb http://ftp.by.debian.org/debian/ bookworm main contrib
+deb http://ftp.by.debian.org/debian/ bookworm-updates main contrib
+deb http://ftp.lt.debian.org/debian/ bookworm main contrib
+deb http://ftp.lt.debian.org/debian/ bookworm-updates main contrib
+deb http://security.debian.org/debian-security bookworm-security main contrib
+
+# defaults come from the apt section of the profile, distros without one can't render sources
+$ curl -w'\n' 'localhost:8080/sources.list?distro=alpine'
+{"error":"distro \"alpine\" isn't apt based, use /config for its repository config"}
+
+# same parameters as key=value arguments print deb822 sources to stdout
+$ go run *.go -cache-ttl 0 sources suite=bookworm format=deb822 top=2 > /etc/apt/sources.list.d/debian.sources
+$ cat /etc/apt/sources.list.d/debian.sources
+Types: deb
+URIs: http://ftp.by.debian.org/debian/ http://ftp.lt.debian.org/debian/
+Suites: bookworm bookworm-updates
+Components: main
+Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg
+
+Types: deb
+URIs: http://security.debian.org/debian-security
+Suites: bookworm-security
+Components: main
+Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg
+
+# apt can use the service itself as a mirror, requests go to the best mirror and fail over to the next ones
+$ go run *.go -proxy-cache-dir /var/cache/mirrorscraper -proxy-cache-size 2147483648
+$ echo 'deb http://localhost:8080/mirror/debian/ bookworm main' > /etc/apt/sources.list
+$ curl -sI localhost:8080/mirror/debian/pool/main/h/hello/hello_2.10-3_amd64.deb | grep '^X-'
+X-Cache: MISS
+X-Mirror: http://ftp.by.debian.org/debian/
+$ curl -sI localhost:8080/mirror/debian/pool/main/h/hello/hello_2.10-3_amd64.deb | grep '^X-'
+X-Cache: HIT
+
+# mirror lists are reloaded on SIGHUP or when the file changes, admin api edits them in place
+$ MIRRORSCRAPER_ADMIN_TOKEN=s3cret go run *.go
+$ kill -HUP $(pgrep mirrorscraper)
+$ curl -w'\n' -H 'Authorization: Bearer s3cret' -X PATCH -d '{"url":"http://ftp.am.debian.org/debian/","disabled":true}' localhost:8080/admin/mirrors
+[{"url":"http://ftp.am.debian.org/debian/","disabled":true},{"url":"http://ftp.au.debian.org/debian/","disabled":false},...]
+$ curl -w'\n' -H 'Authorization: Bearer s3cret' -X POST -d '{"url":"http://mirror.example.org/debian/"}' 'localhost:8080/admin/mirrors?distro=debian'
+$ curl -w'\n' -H 'Authorization: Bearer s3cret' -X DELETE 'localhost:8080/admin/mirrors?url=http://mirror.example.org/debian/'
+$ head -1 mirrors.list
+# disabled http://ftp.am.debian.org/debian/
+
+# every probe is stored in sqlite, history and trends survive restarts
+$ go run *.go -history-db mirrors.db -history-retention 720h
+$ curl -w'\n' 'localhost:8080/history?mirror=http://ftp.by.debian.org/debian/&since=1h&limit=2'
+[{"mirror":"http://ftp.by.debian.org/debian/","time":"2021-02-16T14:57:01.35+02:00","latency":80312744},{"mirror":"http://ftp.by.debian.org/debian/","time":"2021-02-16T14:56:01.33+02:00","latency":0,"error":"Get \"http://ftp.by.debian.org/debian/\": context deadline exceeded"}]
+# moving average: every point covers the window before its end, points are step apart
+$ curl -w'\n' 'localhost:8080/trend?mirror=http://ftp.by.debian.org/debian/&since=2h&window=1h&step=30m'
+[{"mirror":"http://ftp.by.debian.org/debian/","probes":120,"uptime":99.17,"avg_latency":81744391,"series":[{"end":"2021-02-16T13:30:00Z","probes":60,"uptime":100,"avg_latency":80120331},{"end":"2021-02-16T14:00:00Z","probes":60,"uptime":100,"avg_latency":80633508},{"end":"2021-02-16T14:30:00Z","probes":60,"uptime":98.33,"avg_latency":82561270},{"end":"2021-02-16T15:00:00Z","probes":60,"uptime":98.33,"avg_latency":83001544}]}]
+
+# prometheus metrics of all probes since start
+$ curl -s localhost:8080/metrics | grep ftp.by.debian.org | grep -v _bucket
+mirrorscraper_probe_duration_seconds_sum{distro="debian",mirror="ftp.by.debian.org",country="by"} 4.912733
+mirrorscraper_probe_duration_seconds_count{distro="debian",mirror="ftp.by.debian.org",country="by"} 60
+mirrorscraper_probes_total{distro="debian",mirror="ftp.by.debian.org",country="by",result="success"} 60
+mirrorscraper_probes_total{distro="debian",mirror="ftp.by.debian.org",country="by",result="failure"} 1
+mirrorscraper_last_success_timestamp_seconds{distro="debian",mirror="ftp.by.debian.org",country="by"} 1613480221.350
+
+# country filters, codes come from mirrors.list ("<url> <country>") or the host name
+$ curl -w'\n' 'localhost:8080/?country=de,fr,nl&exclude=ftp.fr.debian.org'
+{"fastest_mirror":"http://ftp.nl.debian.org/debian/","latency":40211587,"timing":{"dns":1002117,"connect":19650112,"tls":0,"ttfb":19559358,"total":40211587},"country":"nl","checked_at":"2021-02-16T14:59:01.35+02:00","age":4120448812}
+$ curl -w'\n' 'localhost:8080/?prefer=by,lt,lv'
+{"fastest_mirror":"http://ftp.by.debian.org/debian/","latency":81780824,"timing":{"dns":1203311,"connect":39811205,"tls":0,"ttfb":40601533,"total":81780824},"country":"by","checked_at":"2021-02-16T14:59:01.35+02:00","age":5120448812}
+
+# with -cache-ttl 0 every request probes mirrors live
 $ curl -i -w'\n' localhost:8080/
 HTTP/1.1 200 OK
 Content-Type: application/json
@@ -92,8 +408,29 @@ Date: Tue, 16 Feb 2021 12:56:36 GMT
 Content-Length: 72
 
 {"fastest_mirror":"http://ftp.by.debian.org/debian/","latency":81780824}
-*/
 
-/* TO-DO
-there is no limit on number of goroutines (when multiple calls are done server will use a lot of outgoing connections)
+# when no mirror answers within the timeout
+$ curl -i -w'\n' localhost:8080/
+HTTP/1.1 503 Service Unavailable
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 12:58:10 GMT
+Content-Length: 29
+
+{"error":"no mirror answered"}
+
+$ curl -i -w'\n' 'localhost:8080/?mode=throughput&path=dists/stable/InRelease'
+HTTP/1.1 200 OK
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 13:01:15 GMT
+Content-Length: 225
+
+{"fastest_mirror":"http://ftp.lt.debian.org/debian/","latency":88102335,"timing":{"dns":982112,"connect":43120551,"tls":0,"ttfb":43870010,"total":88102335},"bytes":152016,"duration":61874212,"bytes_per_sec":2456859.1}
+
+$ curl -i -w'\n' 'localhost:8080/rank?top=2&probes=5'
+HTTP/1.1 200 OK
+Content-Type: application/json
+Date: Tue, 16 Feb 2021 13:02:41 GMT
+Content-Length: 303
+
+[{"fastest_mirror":"http://ftp.by.debian.org/debian/","latency":80312744,"min":78820115,"median":80312744,"p95":95120001,"success_rate":1},{"fastest_mirror":"http://ftp.lt.debian.org/debian/","latency":90544120,"min":88102335,"median":90544120,"p95":131870466,"success_rate":0.8}]
 */
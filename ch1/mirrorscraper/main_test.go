@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mirrorServer answers every request with status after delay
+func mirrorServer(t *testing.T, status int, delay time.Duration) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/debian/"
+}
+
+func TestProbeStatus(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusForbidden, true},
+		{http.StatusNotFound, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	p := &profile{Name: "test", ProbePath: "dists/stable/Release"}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			_, err := p.probe(context.Background(), mirrorServer(t, tt.status, 0))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "unexpected status") {
+				t.Errorf("err = %v, want unexpected status", err)
+			}
+		})
+	}
+}
+
+func TestFindFastestSkipsErrorPages(t *testing.T) {
+	broken := mirrorServer(t, http.StatusNotFound, 0)
+	slow := mirrorServer(t, http.StatusOK, 50*time.Millisecond)
+	p := &profile{Name: "test"}
+
+	got, err := findFastest(context.Background(), p, []string{broken, slow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FastestMirror != slow {
+		t.Errorf("fastest = %s, want %s over the mirror answering 404", got.FastestMirror, slow)
+	}
+
+	if _, err := findFastest(context.Background(), p, []string{broken}); err != errNoMirror {
+		t.Errorf("err = %v, want %v when every mirror answers 404", err, errNoMirror)
+	}
+}
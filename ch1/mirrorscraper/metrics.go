@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeBuckets are upper bounds of probe duration histogram in seconds
+var probeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// mirrorMetrics are probe statistics of a single mirror since start
+type mirrorMetrics struct {
+	distro, host, country string
+
+	buckets     []uint64 // cumulative counts of successful probes per probeBuckets bound
+	sum         float64  // seconds spent in successful probes
+	successes   uint64
+	failures    uint64
+	lastSuccess time.Time
+}
+
+// metricsRegistry keeps metrics of all probed mirrors by distro, host and country. a mirror moved to
+// another country through the admin api starts a new series, the old one keeps its last values.
+type metricsRegistry struct {
+	mu      sync.Mutex
+	mirrors map[string]*mirrorMetrics
+}
+
+// globally scoped metrics registry
+var metrics = metricsRegistry{mirrors: make(map[string]*mirrorMetrics)}
+
+// observe accounts a single probe result
+func (m *metricsRegistry) observe(distro, mirror, country string, latency time.Duration, err error) {
+	host := mirrorHost(mirror)
+	key := distro + " " + host + " " + country
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mm, ok := m.mirrors[key]
+	if !ok {
+		mm = &mirrorMetrics{distro: distro, host: host, country: country, buckets: make([]uint64, len(probeBuckets))}
+		m.mirrors[key] = mm
+	}
+	if err != nil {
+		mm.failures++
+		return
+	}
+	seconds := latency.Seconds()
+	for i, bound := range probeBuckets {
+		if seconds <= bound {
+			mm.buckets[i]++
+		}
+	}
+	mm.sum += seconds
+	mm.successes++
+	mm.lastSuccess = time.Now()
+}
+
+// write renders all metrics in prometheus text exposition format, series are sorted by labels
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	all := make([]mirrorMetrics, 0, len(m.mirrors))
+	for _, mm := range m.mirrors {
+		c := *mm
+		c.buckets = append([]uint64(nil), mm.buckets...)
+		all = append(all, c)
+	}
+	m.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].distro != all[j].distro {
+			return all[i].distro < all[j].distro
+		}
+		if all[i].host != all[j].host {
+			return all[i].host < all[j].host
+		}
+		return all[i].country < all[j].country
+	})
+
+	fmt.Fprintln(w, "# HELP mirrorscraper_probe_duration_seconds Time until mirror response headers arrived, successful probes only.")
+	fmt.Fprintln(w, "# TYPE mirrorscraper_probe_duration_seconds histogram")
+	for _, mm := range all {
+		labels := mm.labels()
+		for i, bound := range probeBuckets {
+			fmt.Fprintf(w, "mirrorscraper_probe_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, mm.buckets[i])
+		}
+		fmt.Fprintf(w, "mirrorscraper_probe_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, mm.successes)
+		fmt.Fprintf(w, "mirrorscraper_probe_duration_seconds_sum{%s} %g\n", labels, mm.sum)
+		fmt.Fprintf(w, "mirrorscraper_probe_duration_seconds_count{%s} %d\n", labels, mm.successes)
+	}
+
+	fmt.Fprintln(w, "# HELP mirrorscraper_probes_total Mirror probes by result.")
+	fmt.Fprintln(w, "# TYPE mirrorscraper_probes_total counter")
+	for _, mm := range all {
+		labels := mm.labels()
+		fmt.Fprintf(w, "mirrorscraper_probes_total{%s,result=\"success\"} %d\n", labels, mm.successes)
+		fmt.Fprintf(w, "mirrorscraper_probes_total{%s,result=\"failure\"} %d\n", labels, mm.failures)
+	}
+
+	fmt.Fprintln(w, "# HELP mirrorscraper_last_success_timestamp_seconds Unix time of the last successful probe, 0 if there was none.")
+	fmt.Fprintln(w, "# TYPE mirrorscraper_last_success_timestamp_seconds gauge")
+	for _, mm := range all {
+		var ts float64
+		if !mm.lastSuccess.IsZero() {
+			ts = float64(mm.lastSuccess.UnixNano()) / 1e9
+		}
+		fmt.Fprintf(w, "mirrorscraper_last_success_timestamp_seconds{%s} %.3f\n", mm.labels(), ts)
+	}
+}
+
+// labels renders label pairs shared by all series of the mirror
+func (mm *mirrorMetrics) labels() string {
+	return fmt.Sprintf(`distro="%s",mirror="%s",country="%s"`, escapeLabel(mm.distro), escapeLabel(mm.host), escapeLabel(mm.country))
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes backslash, double quote and line feed in label value
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// metricsHandler exposes probe metrics for prometheus to scrape
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
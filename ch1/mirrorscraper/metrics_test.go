@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	defer func(mirrors map[string]*mirrorMetrics) { metrics.mirrors = mirrors }(metrics.mirrors)
+	metrics.mirrors = make(map[string]*mirrorMetrics)
+
+	const byMirror = "http://ftp.by.debian.org/debian/"
+	metrics.observe("debian", byMirror, "by", 250*time.Millisecond, nil)
+	metrics.observe("debian", byMirror, "by", time.Second, nil)
+	metrics.observe("debian", byMirror, "by", 0, errors.New("timeout"))
+	// the admin api moved the mirror to another country
+	metrics.observe("debian", byMirror, "lt", 40*time.Millisecond, nil)
+	metrics.observe("alpine", "http://dl-cdn.alpinelinux.org/alpine/", "", 0, errors.New("refused"))
+
+	srv := httptest.NewServer(http.HandlerFunc(metricsHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+
+	byLabels := `distro="debian",mirror="ftp.by.debian.org",country="by"`
+	ltLabels := `distro="debian",mirror="ftp.by.debian.org",country="lt"`
+	alpine := `distro="alpine",mirror="dl-cdn.alpinelinux.org",country=""`
+	for _, line := range []string{
+		"# TYPE mirrorscraper_probe_duration_seconds histogram",
+		`mirrorscraper_probe_duration_seconds_bucket{` + byLabels + `,le="0.1"} 0`,
+		`mirrorscraper_probe_duration_seconds_bucket{` + byLabels + `,le="0.25"} 1`,
+		`mirrorscraper_probe_duration_seconds_bucket{` + byLabels + `,le="1"} 2`,
+		`mirrorscraper_probe_duration_seconds_bucket{` + byLabels + `,le="+Inf"} 2`,
+		`mirrorscraper_probe_duration_seconds_sum{` + byLabels + `} 1.25`,
+		`mirrorscraper_probe_duration_seconds_count{` + byLabels + `} 2`,
+		`mirrorscraper_probe_duration_seconds_bucket{` + ltLabels + `,le="0.05"} 1`,
+		`mirrorscraper_probe_duration_seconds_count{` + alpine + `} 0`,
+		"# TYPE mirrorscraper_probes_total counter",
+		`mirrorscraper_probes_total{` + byLabels + `,result="success"} 2`,
+		`mirrorscraper_probes_total{` + byLabels + `,result="failure"} 1`,
+		`mirrorscraper_probes_total{` + ltLabels + `,result="success"} 1`,
+		`mirrorscraper_probes_total{` + ltLabels + `,result="failure"} 0`,
+		`mirrorscraper_probes_total{` + alpine + `,result="failure"} 1`,
+		"# TYPE mirrorscraper_last_success_timestamp_seconds gauge",
+		`mirrorscraper_last_success_timestamp_seconds{` + alpine + `} 0.000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+	for _, labels := range []string{byLabels, ltLabels} {
+		re := regexp.MustCompile(`mirrorscraper_last_success_timestamp_seconds\{` + regexp.QuoteMeta(labels) + `\} (\d+)\.\d{3}\n`)
+		m := re.FindStringSubmatch(body)
+		if m == nil {
+			t.Errorf("no last success of %s", labels)
+			continue
+		}
+		if ts, _ := strconv.ParseInt(m[1], 10, 64); time.Since(time.Unix(ts, 0)) > time.Minute {
+			t.Errorf("last success of %s = %s, want about now", labels, m[1])
+		}
+	}
+	// alpine sorts first, distros are grouped
+	if strings.Index(body, alpine) > strings.Index(body, byLabels) || strings.Index(body, byLabels) > strings.Index(body, ltLabels) {
+		t.Errorf("series aren't sorted by distro, mirror and country")
+	}
+}
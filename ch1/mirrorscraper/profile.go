@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// profile settings (set with command line flags)
+var (
+	profilesPath  string
+	defaultDistro string
+)
+
+func init() {
+	flag.StringVar(&profilesPath, "profiles", "", "json file with distro profiles (default: single debian profile built from flags)")
+	flag.StringVar(&defaultDistro, "distro", "debian", "profile used when request has no distro parameter")
+}
+
+// profile describes how mirrors of a single distribution are listed, probed and checked for freshness
+type profile struct {
+	Name          string       `json:"name"`
+	List          string       `json:"list"`           // file with mirror urls, one per line
+	ProbePath     string       `json:"probe_path"`     // requested in latency mode, relative to mirror root
+	BenchPath     string       `json:"bench_path"`     // downloaded in throughput mode, relative to mirror root
+	ReleasePath   string       `json:"release_path"`   // freshness file relative to mirror root, empty disables freshness checks
+	ReleaseFormat string       `json:"release_format"` // parser of the freshness file: release, timestamp or repomd
+	Reference     string       `json:"reference"`      // mirror trusted as up to date, newest seen is used when empty
+	Template      string       `json:"template"`       // text/template of repository config, gets .Mirror and .Distro
+	Apt           *aptSettings `json:"apt,omitempty"`  // defaults of rendered apt sources, sources.list is refused without it
+
+	mu        sync.RWMutex
+	entries   []mirrorEntry     // whole list file including disabled mirrors
+	mirrors   []string          // enabled mirrors, replaced as a whole on every change
+	countries map[string]string // country codes given in the list file by mirror
+	modTime   time.Time         // of the list file when it was last read or written
+	tmpl      *template.Template
+	cache     resultCache
+}
+
+// aptSettings are distro specific defaults of apt sources, query parameters override them
+type aptSettings struct {
+	Suite      string `json:"suite"`
+	Components string `json:"components"` // space or comma separated
+	Security   string `json:"security"`   // security archive url, empty omits the security suite
+	Keyring    string `json:"keyring"`    // Signed-By of deb822 stanzas, empty omits it
+}
+
+// debianApt are apt defaults of the debian profile
+var debianApt = aptSettings{
+	Suite:      "stable",
+	Components: "main",
+	Security:   "http://security.debian.org/debian-security",
+	Keyring:    "/usr/share/keyrings/debian-archive-keyring.gpg",
+}
+
+// templateData is passed to profile template
+type templateData struct {
+	Mirror string
+	Distro string
+}
+
+// globally scoped profiles by name
+var profiles = make(map[string]*profile)
+
+// loadProfiles reads profiles config file or builds debian profile out of flags, then reads mirror lists
+func loadProfiles(path string) error {
+	var list []*profile
+	if path == "" {
+		list = []*profile{defaultProfile()}
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	for _, p := range list {
+		if err := p.init(); err != nil {
+			return fmt.Errorf("profile %q: %s", p.Name, err)
+		}
+		if _, ok := profiles[p.Name]; ok {
+			return fmt.Errorf("profile %q: defined twice", p.Name)
+		}
+		profiles[p.Name] = p
+	}
+	if _, ok := profiles[defaultDistro]; !ok {
+		return fmt.Errorf("default distro %q has no profile", defaultDistro)
+	}
+	return nil
+}
+
+// defaultProfile is the debian profile configured with command line flags
+func defaultProfile() *profile {
+	apt := debianApt
+	p := &profile{
+		Name:          "debian",
+		List:          listPath,
+		BenchPath:     benchPath,
+		ReleaseFormat: "release",
+		Reference:     reference,
+		Template:      "deb {{.Mirror}} stable main\n",
+		Apt:           &apt,
+	}
+	if suite != "" {
+		p.ReleasePath = "dists/" + suite + "/Release"
+		p.Template = "deb {{.Mirror}} " + suite + " main\n"
+		apt.Suite = suite
+	}
+	return p
+}
+
+// init validates the profile, parses its template and reads its mirror list
+func (p *profile) init() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, path := range []string{p.ProbePath, p.BenchPath, p.ReleasePath} {
+		if err := checkRelativePath(path); err != nil {
+			return err
+		}
+	}
+	if p.ReleasePath != "" {
+		if _, ok := releaseParsers[p.ReleaseFormat]; !ok {
+			return fmt.Errorf("unknown release format %q", p.ReleaseFormat)
+		}
+	}
+	if p.Apt != nil {
+		if err := p.Apt.validate(); err != nil {
+			return fmt.Errorf("apt: %s", err)
+		}
+	}
+	tmpl, err := template.New(p.Name).Parse(p.Template)
+	if err != nil {
+		return err
+	}
+	p.tmpl = tmpl
+	return p.reload()
+}
+
+// checkRelativePath allows only paths inside the mirror
+func checkRelativePath(path string) error {
+	if strings.Contains(path, "://") || strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path must be relative to the mirror root")
+	}
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." {
+			return fmt.Errorf("path must not contain '..'")
+		}
+	}
+	return nil
+}
+
+// profileFor returns profile selected with distro query parameter
+func profileFor(r *http.Request) (*profile, error) {
+	return lookupProfile(r.URL.Query().Get("distro"))
+}
+
+// lookupProfile returns profile by name, empty name means default distro
+func lookupProfile(name string) (*profile, error) {
+	if name == "" {
+		name = defaultDistro
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown distro %q", name)
+	}
+	return p, nil
+}
+
+// url joins the mirror root with a path relative to it
+func (p *profile) url(mirror, path string) string {
+	return strings.TrimSuffix(mirror, "/") + "/" + path
+}
+
+// fastestMirror returns the fastest fresh mirror, from the cache unless it's disabled
+func (p *profile) fastestMirror(ctx context.Context) (fastest, error) {
+	if cacheTTL > 0 {
+		snap, err := p.fresh(ctx, cacheTTL)
+		if err != nil {
+			return fastest{}, err
+		}
+		return snap.fastest(nil)
+	}
+	fresh, _ := checkFreshness(ctx, p)
+	return findFastest(ctx, p, fresh)
+}
+
+// configHandler renders repository config of the profile pointing at the fastest mirror
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	best, err := p.fastestMirror(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{err.Error()})
+		return
+	}
+
+	var b bytes.Buffer
+	if err := p.tmpl.Execute(&b, templateData{best.FastestMirror, p.Name}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// proxy settings (set with command line flags)
+var (
+	proxyAttempts  int
+	proxyCacheDir  string
+	proxyCacheSize int64
+)
+
+func init() {
+	flag.IntVar(&proxyAttempts, "proxy-attempts", 3, "how many ranked mirrors the proxy tries before giving up")
+	flag.StringVar(&proxyCacheDir, "proxy-cache-dir", "", "directory to cache pool/ files fetched by the proxy (empty disables)")
+	flag.Int64Var(&proxyCacheSize, "proxy-cache-size", 10<<30, "max bytes kept in proxy cache, least recently used files are evicted")
+}
+
+// proxyCache is nil when caching is disabled
+var proxyCache *diskCache
+
+// proxyClient gives up on a mirror that doesn't send headers in time, bodies may take as long as they need
+var proxyClient *http.Client
+
+// setupProxy prepares proxy client and cache once flags are parsed
+func setupProxy() error {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = probeTimeout
+	proxyClient = &http.Client{Transport: t}
+
+	if proxyCacheDir == "" {
+		return nil
+	}
+	cache, err := newDiskCache(proxyCacheDir, proxyCacheSize)
+	if err != nil {
+		return err
+	}
+	proxyCache = cache
+	return nil
+}
+
+// forwardedHeaders are request headers passed to the mirror
+var forwardedHeaders = []string{"Accept", "Cache-Control", "If-Modified-Since", "If-None-Match", "If-Range", "Range", "User-Agent"}
+
+// hopHeaders are response headers that belong to a single connection and are not copied
+var hopHeaders = map[string]bool{
+	"Connection": true, "Keep-Alive": true, "Proxy-Authenticate": true, "Proxy-Authorization": true,
+	"Te": true, "Trailer": true, "Transfer-Encoding": true, "Upgrade": true,
+}
+
+// proxyHandler forwards /mirror/<distro>/<path> to the best mirror of the distro,
+// failing over to the next ranked mirror on errors and 5xx responses
+func proxyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	distro, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/mirror/"), "/")
+	p, err := lookupProfile(distro)
+	if err != nil || distro == "" {
+		http.Error(w, fmt.Sprintf("404 - unknown distro %q", distro), http.StatusNotFound)
+		return
+	}
+	if err := checkRelativePath(rest); err != nil {
+		http.Error(w, "400 - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// immutable pool files of plain GET requests are cached, anything else always goes upstream.
+	// directories have no extension, caching their listings would shadow files below them.
+	key := path.Join(p.Name, rest)
+	pool := proxyCache != nil && strings.HasPrefix(rest, "pool/") && path.Ext(rest) != ""
+	cacheable := pool && r.Method == http.MethodGet && r.Header.Get("Range") == ""
+	if pool {
+		if file, info, ok := proxyCache.open(key); ok {
+			defer file.Close()
+			w.Header().Set("X-Cache", "HIT")
+			http.ServeContent(w, r, path.Base(rest), info.ModTime(), file)
+			return
+		}
+	}
+
+	mirrors, err := p.bestMirrors(r.Context(), proxyAttempts)
+	if err != nil {
+		http.Error(w, "503 - "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	for _, mirror := range mirrors {
+		resp, err := forward(r, p.url(mirror, rest))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("proxy: %s", err)
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			log.Printf("proxy: %s: %s", p.url(mirror, rest), resp.Status)
+			continue
+		}
+		defer resp.Body.Close()
+
+		for name, values := range resp.Header {
+			if !hopHeaders[name] {
+				w.Header()[name] = values
+			}
+		}
+		w.Header().Set("X-Mirror", mirror)
+		if cacheable && resp.StatusCode == http.StatusOK {
+			w.Header().Set("X-Cache", "MISS")
+			w.WriteHeader(resp.StatusCode)
+			if err := proxyCache.fill(key, resp, w); err != nil {
+				log.Printf("proxy: cache %s: %s", key, err)
+			}
+			return
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	http.Error(w, "502 - no mirror could serve the request", http.StatusBadGateway)
+}
+
+// forward sends the client request to target keeping the headers apt relies on
+func forward(r *http.Request, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range forwardedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return proxyClient.Do(req)
+}
+
+// diskCache stores files under dir up to max bytes evicting least recently used ones
+type diskCache struct {
+	dir string
+	max int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // of *cacheEntry, most recently used in front
+	items map[string]*list.Element
+}
+
+// cacheEntry is a single cached file
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// tempPrefix marks files being downloaded, they are never served and are removed on start
+const tempPrefix = ".tmp-"
+
+// newDiskCache creates dir if needed and indexes files left there by previous runs, oldest first
+func newDiskCache(dir string, max int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{dir: dir, max: max, lru: list.New(), items: make(map[string]*list.Element)}
+
+	var found []fs.FileInfo
+	var keys []string
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), tempPrefix) {
+			return os.Remove(name)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		found = append(found, info)
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(found))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return found[order[i]].ModTime().Before(found[order[j]].ModTime()) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range order {
+		c.add(keys[i], found[i].Size())
+	}
+	log.Printf("proxy cache: %d files, %d bytes in %s", c.lru.Len(), c.size, dir)
+	return c, nil
+}
+
+// path returns file name of the key
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key))
+}
+
+// open returns the cached file of the key and marks it as recently used
+func (c *diskCache) open(key string) (*os.File, fs.FileInfo, bool) {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		c.remove(key)
+		return nil, nil, false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, false
+	}
+	return file, info, true
+}
+
+// fill streams the response body to w while saving it under key.
+// the file is kept only if the whole body was read and delivered.
+func (c *diskCache) fill(key string, resp *http.Response, w io.Writer) error {
+	if resp.ContentLength > c.max {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, tempPrefix)
+	if err != nil {
+		io.Copy(w, resp.Body)
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	n, err := io.Copy(w, io.TeeReader(resp.Body, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("got %d of %d bytes", n, resp.ContentLength)
+	}
+
+	name := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, n)
+	return nil
+}
+
+// add indexes the key as most recently used and evicts old files over the size cap, c.mu must be held
+func (c *diskCache) add(key string, size int64) {
+	if elem, ok := c.items[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key, size})
+	c.size += size
+
+	for c.size > c.max {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.items, entry.key)
+		c.size -= entry.size
+		if err := os.Remove(c.path(entry.key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("proxy cache: evict %s: %s", entry.key, err)
+		}
+	}
+}
+
+// remove drops the key from the index
+func (c *diskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.size -= elem.Value.(*cacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.items, key)
+	}
+}
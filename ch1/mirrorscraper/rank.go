@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ranking query defaults and limits
+const (
+	defaultTop    = 10
+	maxTop        = 1000
+	defaultProbes = 3
+	maxProbes     = 20
+)
+
+// ranked mirror responce struct, latency of the embedded fastest is the median
+type ranked struct {
+	fastest
+	Min         time.Duration `json:"min"`
+	Median      time.Duration `json:"median"`
+	P95         time.Duration `json:"p95"`
+	SuccessRate float64       `json:"success_rate"`
+}
+
+// rankHandler returns top mirrors sorted by success rate and median latency
+func rankHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := profileFor(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	top, err := intParam(r, "top", defaultTop, 1, maxTop)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	probes, err := intParam(r, "probes", defaultProbes, 1, maxProbes)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	ranking := rankMirrors(r.Context(), p, probes)
+	if len(ranking) == 0 {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{errNoMirror.Error()})
+		return
+	}
+	if len(ranking) > top {
+		ranking = ranking[:top]
+	}
+	writeJSON(w, http.StatusOK, ranking)
+}
+
+// intParam parses optional integer query parameter and checks it is within [min, max]
+func intParam(r *http.Request, name string, def, min, max int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// rankMirrors probes every mirror of the profile n times and returns the ones that answered at least once, best first
+func rankMirrors(ctx context.Context, p *profile, n int) []ranked {
+	mirrors := p.activeMirrors()
+	results := make([]ranked, len(mirrors))
+	runPool(ctx, mirrors, func(i int, mirror string) {
+		// probes of a single mirror run one after another so they don't compete with each other
+		var latencies []time.Duration
+		for attempt := 0; attempt < n && ctx.Err() == nil; attempt++ {
+			if t, err := p.probe(ctx, mirror); err == nil {
+				latencies = append(latencies, t.Total)
+			}
+		}
+		results[i] = summarize(mirror, latencies, n)
+	})
+
+	ranking := results[:0]
+	for _, result := range results {
+		if result.SuccessRate > 0 {
+			ranking = append(ranking, result)
+		}
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].SuccessRate != ranking[j].SuccessRate {
+			return ranking[i].SuccessRate > ranking[j].SuccessRate
+		}
+		return ranking[i].Median < ranking[j].Median
+	})
+	return ranking
+}
+
+// summarize computes latency statistics out of successful probes of total attempts
+func summarize(mirror string, latencies []time.Duration, attempts int) ranked {
+	result := ranked{fastest: fastest{FastestMirror: mirror}}
+	if len(latencies) == 0 {
+		return result
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.Min = latencies[0]
+	result.Median = percentile(latencies, 0.5)
+	result.P95 = percentile(latencies, 0.95)
+	result.Latency = result.Median
+	result.SuccessRate = float64(len(latencies)) / float64(attempts)
+	return result
+}
+
+// percentile returns the nearest-rank percentile p (0..1] of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// release freshness settings (set with command line flags)
+var (
+	suite     string
+	reference string
+	maxLag    time.Duration
+)
+
+func init() {
+	flag.StringVar(&suite, "suite", "stable", "suite whose Release file is compared across mirrors, empty disables (default profile)")
+	flag.StringVar(&reference, "reference", "", "mirror url whose Release is trusted as up to date, newest seen if empty (default profile)")
+	flag.DurationVar(&maxLag, "max-lag", 12*time.Hour, "how far a mirror's Release date may lag behind before it's stale")
+}
+
+// release holds the freshness file fields used for freshness checks
+type release struct {
+	Date       time.Time
+	ValidUntil time.Time
+	Digest     string // sha256 over checksums or content, equal for mirrors serving identical indices
+}
+
+// releaseParsers read freshness files by profile release format
+var releaseParsers = map[string]func(io.Reader) (release, error){
+	"release":   parseRelease,
+	"timestamp": parseTimestamp,
+	"repomd":    parseRepomd,
+}
+
+// rejection tells why a mirror was excluded from results
+type rejection struct {
+	Mirror string `json:"mirror"`
+	Reason string `json:"reason"`
+}
+
+// releaseDateLayouts are date formats seen in Release files
+var releaseDateLayouts = []string{time.RFC1123, time.RFC1123Z}
+
+// checkFreshness fetches the freshness file from every mirror of the profile and splits mirrors into fresh and rejected ones
+func checkFreshness(ctx context.Context, p *profile) ([]string, []rejection) {
+	mirrors := p.activeMirrors()
+	if p.ReleasePath == "" {
+		return mirrors, nil
+	}
+
+	releases := make([]release, len(mirrors))
+	errs := make([]error, len(mirrors))
+	runPool(ctx, mirrors, func(i int, mirror string) {
+		releases[i], errs[i] = fetchRelease(ctx, p, mirror)
+	})
+
+	// newest release seen is the baseline unless reference mirror is given
+	var baseline release
+	if p.Reference != "" {
+		ref, err := fetchRelease(ctx, p, p.Reference)
+		if err != nil {
+			// can't judge without baseline, better keep every mirror than reject all
+			return mirrors, []rejection{{p.Reference, fmt.Sprintf("reference release: %s", err)}}
+		}
+		baseline = ref
+	} else {
+		for i, rel := range releases {
+			if errs[i] == nil && rel.Date.After(baseline.Date) {
+				baseline = rel
+			}
+		}
+	}
+
+	var fresh []string
+	var rejected []rejection
+	now := time.Now()
+	for i, mirror := range mirrors {
+		rel := releases[i]
+		reason := ""
+		switch {
+		case errs[i] != nil:
+			reason = fmt.Sprintf("release: %s", errs[i])
+		case !rel.ValidUntil.IsZero() && rel.ValidUntil.Before(now):
+			reason = fmt.Sprintf("release expired at %s", rel.ValidUntil.Format(time.RFC3339))
+		case baseline.Date.Sub(rel.Date) > maxLag:
+			reason = fmt.Sprintf("release is %s behind %s", baseline.Date.Sub(rel.Date), baseline.Date.Format(time.RFC3339))
+		case rel.Date.Equal(baseline.Date) && rel.Digest != baseline.Digest:
+			reason = "release checksums differ from baseline with the same date"
+		}
+		if reason != "" {
+			rejected = append(rejected, rejection{mirror, reason})
+			continue
+		}
+		fresh = append(fresh, mirror)
+	}
+	return fresh, rejected
+}
+
+// fetchRelease downloads and parses freshness file of the profile from the mirror
+func fetchRelease(ctx context.Context, p *profile, mirror string) (release, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	target := p.url(mirror, p.ReleasePath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return release{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return release{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return release{}, fmt.Errorf("get %s: unexpected status %s", target, resp.Status)
+	}
+	return releaseParsers[p.ReleaseFormat](resp.Body)
+}
+
+// parseRelease reads Date, Valid-Until and checksum sections out of a debian style Release file
+func parseRelease(r io.Reader) (release, error) {
+	var rel release
+	digest := sha256.New()
+	inChecksums := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// checksum entries are continuation lines starting with a space
+		if strings.HasPrefix(line, " ") {
+			if inChecksums {
+				fmt.Fprintln(digest, strings.TrimSpace(line))
+			}
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		inChecksums = false
+		switch field {
+		case "Date":
+			date, err := parseReleaseDate(value)
+			if err != nil {
+				return release{}, err
+			}
+			rel.Date = date
+		case "Valid-Until":
+			date, err := parseReleaseDate(value)
+			if err != nil {
+				return release{}, err
+			}
+			rel.ValidUntil = date
+		case "MD5Sum", "SHA1", "SHA256", "SHA512":
+			inChecksums = true
+			fmt.Fprintln(digest, field)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return release{}, err
+	}
+	if rel.Date.IsZero() {
+		return release{}, fmt.Errorf("release has no Date field")
+	}
+	rel.Digest = hex.EncodeToString(digest.Sum(nil))
+	return rel, nil
+}
+
+// parseReleaseDate tries the date formats used by archive tools
+func parseReleaseDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range releaseDateLayouts {
+		var date time.Time
+		if date, err = time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad release date %q: %s", value, err)
+}
+
+// parseTimestamp reads a file holding unix time of the last sync, like alpine's last-updated
+func parseTimestamp(r io.Reader) (release, error) {
+	data, err := io.ReadAll(io.LimitReader(r, 1<<10))
+	if err != nil {
+		return release{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return release{}, fmt.Errorf("bad timestamp: %s", err)
+	}
+	sum := sha256.Sum256(data)
+	return release{Date: time.Unix(seconds, 0).UTC(), Digest: hex.EncodeToString(sum[:])}, nil
+}
+
+// parseRepomd reads revision of an rpm repository repomd.xml, createrepo sets it to unix time of the build
+func parseRepomd(r io.Reader) (release, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return release{}, err
+	}
+	var repomd struct {
+		Revision string `xml:"revision"`
+	}
+	if err := xml.Unmarshal(data, &repomd); err != nil {
+		return release{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(repomd.Revision), 10, 64)
+	if err != nil {
+		return release{}, fmt.Errorf("bad repomd revision: %s", err)
+	}
+	sum := sha256.Sum256(data)
+	return release{Date: time.Unix(seconds, 0).UTC(), Digest: hex.EncodeToString(sum[:])}, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultListURL is the official debian mirror list page
+const defaultListURL = "https://www.debian.org/mirror/list"
+
+// mirrorInfo holds everything the debian mirror list page tells about a single mirror
+type mirrorInfo struct {
+	Host          string   `json:"host"`
+	Country       string   `json:"country"`
+	URL           string   `json:"url"`
+	HTTPSURL      string   `json:"https_url,omitempty"`
+	Protocols     []string `json:"protocols"`
+	Architectures []string `json:"architectures"`
+}
+
+// mirrorListRe matches page elements in document order:
+// 1 country header, 2-5 primary mirrors table row, 6 mirror site name, 7-8 protocol link, 9 architectures
+var mirrorListRe = regexp.MustCompile(`(?is)` +
+	`<h3[^>]*>\s*(?:<a[^>]*>)?([^<]+?)(?:</a>)?\s*</h3>` +
+	`|<tr[^>]*>\s*<td[^>]*>(?:<a[^>]*>)?([^<]*)(?:</a>)?</td>\s*<td[^>]*><a[^>]*href="([^"]+)"[^>]*>([^<]+)</a>\s*</td>\s*<td[^>]*>(.*?)</td>` +
+	`|<strong>([^<]+)</strong>` +
+	`|\b(HTTPS?|FTP|rsync):\s*<a[^>]*href="([^"]+)"` +
+	`|(?:Includes\s+)?architectures:\s*(.*?)(?:<br|</)`)
+
+// tagRe strips html tags out of table cells
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+// runScrape implements "scrape" subcommand: downloads the mirror list page and saves it to disk
+func runScrape(args []string) error {
+	fs := flag.NewFlagSet("scrape", flag.ExitOnError)
+	url := fs.String("url", defaultListURL, "mirror list page to scrape")
+	out := fs.String("o", "mirrors.list", "file to write mirror urls to")
+	jsonOut := fs.String("json", "mirrors.json", "file to write mirror details to (empty to skip)")
+	scheme := fs.String("scheme", "http", "preferred scheme of urls written to the list: http or https")
+	timeout := fs.Duration("timeout", 30*time.Second, "deadline for downloading the page")
+	fs.Parse(args)
+	if *scheme != "http" && *scheme != "https" {
+		return fmt.Errorf("unknown scheme %q", *scheme)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	infos, err := scrapeMirrors(client, *url)
+	if err != nil {
+		return err
+	}
+	log.Printf("Scraped %d mirrors from %s", len(infos), *url)
+
+	if err := writeList(*out, infos, *scheme); err != nil {
+		return err
+	}
+	if *jsonOut == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(*jsonOut, append(data, '\n'))
+}
+
+// scrapeMirrors downloads the mirror list page and parses it
+func scrapeMirrors(client *http.Client, url string) ([]mirrorInfo, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+	return parseMirrorList(resp.Body)
+}
+
+// parseMirrorList extracts mirrors from the html of the debian mirror list page.
+// mirrors found both in primary table and in the per country list are merged by host.
+func parseMirrorList(r io.Reader) ([]mirrorInfo, error) {
+	page, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	byHost := make(map[string]*mirrorInfo)
+	get := func(host string) *mirrorInfo {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if m, ok := byHost[host]; ok {
+			return m
+		}
+		m := &mirrorInfo{Host: host}
+		byHost[host] = m
+		return m
+	}
+
+	var country string
+	var current *mirrorInfo
+	for _, match := range mirrorListRe.FindAllStringSubmatch(string(page), -1) {
+		switch {
+		case match[1] != "":
+			country = cleanText(match[1])
+			current = nil
+		case match[3] != "":
+			m := get(match[4])
+			m.Country = cleanText(match[2])
+			m.addProtocol(match[3])
+			m.addArchitectures(cleanText(tagRe.ReplaceAllString(match[5], " ")))
+			current = nil
+		case match[6] != "":
+			current = get(match[6])
+			if current.Country == "" {
+				current.Country = country
+			}
+		case match[7] != "" && current != nil:
+			current.addProtocol(match[8])
+		case match[9] != "" && current != nil:
+			current.addArchitectures(cleanText(match[9]))
+		}
+	}
+
+	infos := make([]mirrorInfo, 0, len(byHost))
+	for _, m := range byHost {
+		if len(m.Protocols) == 0 {
+			continue // not a mirror, just some bold text
+		}
+		infos = append(infos, *m)
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no mirrors found on the page")
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Country != infos[j].Country {
+			return infos[i].Country < infos[j].Country
+		}
+		return infos[i].Host < infos[j].Host
+	})
+	return infos, nil
+}
+
+// addProtocol records the protocol of the link and keeps http url as the mirror url, https one is kept aside
+func (m *mirrorInfo) addProtocol(link string) {
+	link = html.UnescapeString(link)
+	proto := strings.ToLower(link)
+	if i := strings.Index(proto, "://"); i > 0 {
+		proto = proto[:i]
+	}
+	for _, p := range m.Protocols {
+		if p == proto {
+			return
+		}
+	}
+	m.Protocols = append(m.Protocols, proto)
+	if proto == "https" {
+		m.HTTPSURL = link
+	}
+	if proto == "http" || (proto == "https" && m.URL == "") {
+		m.URL = link
+	}
+}
+
+// addArchitectures merges space separated architectures into the mirror, excluded ones like !ia64 are skipped
+func (m *mirrorInfo) addArchitectures(archs string) {
+	for _, arch := range strings.Fields(archs) {
+		if strings.HasPrefix(arch, "!") {
+			continue
+		}
+		arch = strings.Trim(arch, ",")
+		found := false
+		for _, a := range m.Architectures {
+			if a == arch {
+				found = true
+				break
+			}
+		}
+		if !found && arch != "" {
+			m.Architectures = append(m.Architectures, arch)
+		}
+	}
+}
+
+// cleanText unescapes html entities and collapses whitespace
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// writeList writes one mirror url per line, the format readList expects.
+// https urls are used for mirrors supporting it when scheme is https.
+func writeList(path string, infos []mirrorInfo, scheme string) error {
+	var b strings.Builder
+	for _, m := range infos {
+		switch {
+		case scheme == "https" && m.HTTPSURL != "":
+			fmt.Fprintln(&b, m.HTTPSURL)
+		case m.URL != "":
+			fmt.Fprintln(&b, m.URL)
+		}
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
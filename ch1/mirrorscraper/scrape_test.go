@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mirrorListServer serves the saved copy of the debian mirror list page
+func mirrorListServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	page, err := os.ReadFile("testdata/mirror-list.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestScrapeMirrors(t *testing.T) {
+	srv := mirrorListServer(t)
+	infos, err := scrapeMirrors(srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byHost := make(map[string]mirrorInfo)
+	for _, m := range infos {
+		byHost[m.Host] = m
+	}
+	if len(byHost) != 6 {
+		t.Errorf("got %d mirrors, want 6: %v", len(byHost), infos)
+	}
+
+	allArchs := []string{"amd64", "arm64", "armel", "armhf", "i386", "mips64el", "mipsel", "ppc64el", "s390x"}
+	tests := []struct {
+		host      string
+		country   string
+		url       string
+		httpsURL  string
+		protocols []string
+		archs     []string
+	}{
+		{"ftp.ar.debian.org", "Argentina", "http://ftp.ar.debian.org/debian/", "", []string{"http"}, allArchs},
+		{"ftp.at.debian.org", "Austria", "http://ftp.at.debian.org/debian/", "https://ftp.at.debian.org/debian/", []string{"http", "https", "rsync"}, allArchs},
+		{"debian.unnoba.edu.ar", "Argentina", "http://debian.unnoba.edu.ar/debian/", "", []string{"http"}, []string{"amd64", "i386"}},
+		{"debian.mur.at", "Austria", "http://debian.mur.at/debian/", "https://debian.mur.at/debian/", []string{"http", "https"}, []string{"amd64", "arm64", "armhf", "i386"}},
+		{"mirror.fsmg.org.nz", "New Zealand", "https://mirror.fsmg.org.nz/debian/", "https://mirror.fsmg.org.nz/debian/", []string{"https"}, []string{"amd64", "arm64"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			m, ok := byHost[tt.host]
+			if !ok {
+				t.Fatalf("mirror not found")
+			}
+			if m.Country != tt.country {
+				t.Errorf("country = %q, want %q", m.Country, tt.country)
+			}
+			if m.URL != tt.url || m.HTTPSURL != tt.httpsURL {
+				t.Errorf("urls = %q, %q, want %q, %q", m.URL, m.HTTPSURL, tt.url, tt.httpsURL)
+			}
+			if !reflect.DeepEqual(m.Protocols, tt.protocols) {
+				t.Errorf("protocols = %v, want %v", m.Protocols, tt.protocols)
+			}
+			if !reflect.DeepEqual(m.Architectures, tt.archs) {
+				t.Errorf("architectures = %v, want %v", m.Architectures, tt.archs)
+			}
+		})
+	}
+}
+
+func TestScrapeMirrorsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"bad status", http.StatusNotFound, "", "unexpected status"},
+		{"no mirrors", http.StatusOK, "<html><strong>Note</strong></html>", "no mirrors found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			_, err := scrapeMirrors(srv.Client(), srv.URL)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunScrape(t *testing.T) {
+	srv := mirrorListServer(t)
+	tests := []struct {
+		scheme string
+		want   []string
+	}{
+		{"http", []string{
+			"http://debian.unnoba.edu.ar/debian/",
+			"http://ftp.ar.debian.org/debian/",
+			"http://debian.mur.at/debian/",
+			"http://ftp.at.debian.org/debian/",
+			"http://ftp.cz.debian.org/debian/",
+			"https://mirror.fsmg.org.nz/debian/",
+		}},
+		{"https", []string{
+			"http://debian.unnoba.edu.ar/debian/",
+			"http://ftp.ar.debian.org/debian/",
+			"https://debian.mur.at/debian/",
+			"https://ftp.at.debian.org/debian/",
+			"http://ftp.cz.debian.org/debian/",
+			"https://mirror.fsmg.org.nz/debian/",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			dir := t.TempDir()
+			list, sidecar := filepath.Join(dir, "mirrors.list"), filepath.Join(dir, "mirrors.json")
+			if err := runScrape([]string{"-url", srv.URL, "-o", list, "-json", sidecar, "-scheme", tt.scheme}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Fields(string(data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mirrors.list = %v, want %v", got, tt.want)
+			}
+
+			data, err = os.ReadFile(sidecar)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var infos []mirrorInfo
+			if err := json.Unmarshal(data, &infos); err != nil {
+				t.Fatal(err)
+			}
+			if len(infos) != len(tt.want) {
+				t.Fatalf("json sidecar has %d mirrors, want %d", len(infos), len(tt.want))
+			}
+			for i, m := range infos {
+				if m.Country == "" || len(m.Protocols) == 0 {
+					t.Errorf("json sidecar mirror %d incomplete: %+v", i, m)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// maxSources limits mirrors of a single sources file
+const maxSources = 10
+
+// aptWordRe matches suites and components, nothing that could break a sources line
+var aptWordRe = regexp.MustCompile(`^[A-Za-z0-9._/+-]+$`)
+
+// sourcesOptions are query parameters of sources.list endpoint and arguments of sources cli mode
+type sourcesOptions struct {
+	Format     string // list or deb822
+	Suite      string
+	Components string
+	Updates    bool
+	Security   string // security mirror url, empty omits security suite
+	Keyring    string // Signed-By of deb822 stanzas, empty omits it
+	Top        int    // number of mirrors used, the rest are fallbacks of the first
+	Mirrors    []string
+}
+
+// sourcesTemplates render sourcesOptions by format
+var sourcesTemplates = map[string]*template.Template{
+	"list": template.Must(template.New("list").Parse(
+		`{{range .Mirrors}}deb {{.}} {{$.Suite}} {{$.Components}}
+{{if $.Updates}}deb {{.}} {{$.Suite}}-updates {{$.Components}}
+{{end}}{{end}}{{with .Security}}deb {{.}} {{$.Suite}}-security {{$.Components}}
+{{end}}`)),
+	"deb822": template.Must(template.New("deb822").Funcs(template.FuncMap{"join": strings.Join}).Parse(
+		`Types: deb
+URIs: {{join .Mirrors " "}}
+Suites: {{.Suite}}{{if .Updates}} {{.Suite}}-updates{{end}}
+Components: {{.Components}}
+{{with .Keyring}}Signed-By: {{.}}
+{{end}}{{with .Security}}
+Types: deb
+URIs: {{.}}
+Suites: {{$.Suite}}-security
+Components: {{$.Components}}
+{{with $.Keyring}}Signed-By: {{.}}
+{{end}}{{end}}`)),
+}
+
+// parseSourcesOptions reads and validates sources options, unset ones get defaults of the profile
+func parseSourcesOptions(q url.Values, apt aptSettings) (sourcesOptions, error) {
+	o := sourcesOptions{
+		Format:   "list",
+		Suite:    apt.Suite,
+		Updates:  true,
+		Security: apt.Security,
+		Keyring:  apt.Keyring,
+		Top:      1,
+	}
+	if v := q.Get("format"); v != "" {
+		o.Format = v
+	}
+	if _, ok := sourcesTemplates[o.Format]; !ok {
+		return o, fmt.Errorf("unknown format %q, use list or deb822", o.Format)
+	}
+	if v := q.Get("suite"); v != "" {
+		o.Suite = v
+	}
+	if !aptWordRe.MatchString(o.Suite) {
+		return o, fmt.Errorf("bad suite %q", o.Suite)
+	}
+
+	components := splitComponents(q.Get("components"))
+	if len(components) == 0 {
+		components = splitComponents(apt.Components)
+	}
+	if len(components) == 0 {
+		components = []string{"main"}
+	}
+	for _, c := range components {
+		if !aptWordRe.MatchString(c) {
+			return o, fmt.Errorf("bad component %q", c)
+		}
+	}
+	o.Components = strings.Join(components, " ")
+
+	if v := q.Get("updates"); v != "" {
+		updates, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("updates must be a boolean")
+		}
+		o.Updates = updates
+	}
+	if v := q.Get("security"); v != "" {
+		o.Security = v
+	}
+	if o.Security == "none" {
+		o.Security = ""
+	}
+	if err := checkSecurity(o.Security); err != nil {
+		return o, err
+	}
+	if v, ok := q["keyring"]; ok {
+		o.Keyring = v[0]
+	}
+	if err := checkKeyring(o.Keyring); err != nil {
+		return o, err
+	}
+
+	if v := q.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 || top > maxSources {
+			return o, fmt.Errorf("top must be an integer between 1 and %d", maxSources)
+		}
+		o.Top = top
+	}
+	return o, nil
+}
+
+// validate checks apt defaults of a profile the same way query parameters are checked
+func (a *aptSettings) validate() error {
+	if !aptWordRe.MatchString(a.Suite) {
+		return fmt.Errorf("bad suite %q", a.Suite)
+	}
+	for _, c := range splitComponents(a.Components) {
+		if !aptWordRe.MatchString(c) {
+			return fmt.Errorf("bad component %q", c)
+		}
+	}
+	if err := checkSecurity(a.Security); err != nil {
+		return err
+	}
+	return checkKeyring(a.Keyring)
+}
+
+// splitComponents splits a space or comma separated component list
+func splitComponents(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// checkSecurity allows empty or an http(s) url that can't break a sources line
+func checkSecurity(security string) error {
+	if security == "" {
+		return nil
+	}
+	if u, err := url.Parse(security); err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.ContainsAny(security, " \t\r\n") {
+		return fmt.Errorf("security must be an http(s) url or none")
+	}
+	return nil
+}
+
+// checkKeyring allows keyring paths without whitespace
+func checkKeyring(keyring string) error {
+	if strings.ContainsAny(keyring, " \t\r\n") {
+		return fmt.Errorf("bad keyring %q", keyring)
+	}
+	return nil
+}
+
+// bestMirrors returns up to n fresh mirrors of the profile that answered, fastest first
+func (p *profile) bestMirrors(ctx context.Context, n int) ([]string, error) {
+	var results []probeResult
+	if cacheTTL > 0 {
+		snap, err := p.fresh(ctx, cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		results = snap.Results
+	} else {
+		fresh, _ := checkFreshness(ctx, p)
+		results = checkAll(ctx, p, fresh)
+	}
+
+	var best []string
+	for _, result := range results {
+		if result.Error != "" || len(best) == n {
+			break
+		}
+		best = append(best, result.Mirror)
+	}
+	if len(best) == 0 {
+		return nil, errNoMirror
+	}
+	return best, nil
+}
+
+// writeSources picks the best mirrors of the profile selected in q and renders apt sources to w
+func writeSources(ctx context.Context, w io.Writer, q url.Values) (status int, err error) {
+	p, err := lookupProfile(q.Get("distro"))
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	if p.Apt == nil {
+		return http.StatusBadRequest, fmt.Errorf("distro %q isn't apt based, use /config for its repository config", p.Name)
+	}
+	o, err := parseSourcesOptions(q, *p.Apt)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if o.Mirrors, err = p.bestMirrors(ctx, o.Top); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+
+	var b bytes.Buffer
+	if err := sourcesTemplates[o.Format].Execute(&b, o); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	_, err = w.Write(b.Bytes())
+	return http.StatusOK, err
+}
+
+// sourcesHandler serves apt sources for the fastest mirrors as plain text
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	if status, err := writeSources(r.Context(), &b, r.URL.Query()); err != nil {
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// runSources implements "sources" cli mode, arguments are the endpoint query parameters as key=value pairs
+func runSources(ctx context.Context, w io.Writer, args []string) error {
+	q := url.Values{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("argument %q is not key=value", arg)
+		}
+		q.Add(key, value)
+	}
+	_, err := writeSources(ctx, w, q)
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseSourcesOptions(t *testing.T) {
+	ubuntu := aptSettings{
+		Suite:      "jammy",
+		Components: "main restricted universe multiverse",
+		Security:   "http://security.ubuntu.com/ubuntu",
+		Keyring:    "/usr/share/keyrings/ubuntu-archive-keyring.gpg",
+	}
+	tests := []struct {
+		name    string
+		apt     aptSettings
+		query   string
+		want    sourcesOptions
+		wantErr string
+	}{
+		{
+			name: "debian defaults",
+			apt:  debianApt,
+			want: sourcesOptions{Format: "list", Suite: "stable", Components: "main", Updates: true, Security: debianApt.Security, Keyring: debianApt.Keyring, Top: 1},
+		},
+		{
+			name: "ubuntu defaults",
+			apt:  ubuntu,
+			want: sourcesOptions{Format: "list", Suite: "jammy", Components: "main restricted universe multiverse", Updates: true, Security: ubuntu.Security, Keyring: ubuntu.Keyring, Top: 1},
+		},
+		{
+			name:  "query overrides profile",
+			apt:   ubuntu,
+			query: "suite=noble&components=main,universe&security=none&keyring=&format=deb822&top=3&updates=false",
+			want:  sourcesOptions{Format: "deb822", Suite: "noble", Components: "main universe", Top: 3},
+		},
+		{name: "bad suite", apt: debianApt, query: "suite=stable%20main", wantErr: "bad suite"},
+		{name: "bad component", apt: debianApt, query: "components=main%3Brm", wantErr: "bad component"},
+		{name: "bad security", apt: debianApt, query: "security=ftp://x", wantErr: "security must be"},
+		{name: "bad top", apt: debianApt, query: "top=11", wantErr: "top must be"},
+		{name: "bad format", apt: debianApt, query: "format=yum", wantErr: "unknown format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			got, err := parseSourcesOptions(q, tt.apt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Format != tt.want.Format || got.Suite != tt.want.Suite || got.Components != tt.want.Components ||
+				got.Updates != tt.want.Updates || got.Security != tt.want.Security || got.Keyring != tt.want.Keyring || got.Top != tt.want.Top {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSourcesRefusesNonAptDistro(t *testing.T) {
+	profiles["alpine-test"] = &profile{Name: "alpine-test"}
+	defer delete(profiles, "alpine-test")
+
+	var b bytes.Buffer
+	status, err := writeSources(context.Background(), &b, url.Values{"distro": {"alpine-test"}})
+	if status != http.StatusBadRequest || err == nil || !strings.Contains(err.Error(), "isn't apt based") {
+		t.Errorf("status = %d, err = %v, want 400 for a non apt distro", status, err)
+	}
+	if err := runSources(context.Background(), &b, []string{"distro=alpine-test"}); err == nil {
+		t.Errorf("sources cli mode accepted a non apt distro")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// timing is a per-phase breakdown of a single probe.
+// ttfb is server think time between request written and first response byte,
+// total is the whole time until response headers arrived.
+type timing struct {
+	DNS     time.Duration `json:"dns"`
+	Connect time.Duration `json:"connect"`
+	TLS     time.Duration `json:"tls"`
+	TTFB    time.Duration `json:"ttfb"`
+	Total   time.Duration `json:"total"`
+}
+
+// probeClient never reuses connections so every probe pays and measures the full connection setup
+var probeClient = &http.Client{
+	Transport: func() http.RoundTripper {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DisableKeepAlives = true
+		return t
+	}(),
+}
+
+// phaseTracer collects httptrace timestamps, hooks may fire from dialer goroutines hence the lock
+type phaseTracer struct {
+	mu                       sync.Mutex
+	start                    time.Time
+	dnsStart, dnsDone        time.Time
+	connectStart, connectEnd time.Time
+	tlsStart, tlsDone        time.Time
+	wrote, firstByte         time.Time
+}
+
+// trace returns ctx carrying hooks that record phase timestamps into t
+func (t *phaseTracer) trace(ctx context.Context) context.Context {
+	// parallel dials and redirects fire hooks more than once, starts keep the first and ends the last timestamp
+	set := func(field *time.Time, first bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !first || field.IsZero() {
+			*field = time.Now()
+		}
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { set(&t.dnsStart, true) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { set(&t.dnsDone, false) },
+		ConnectStart:         func(string, string) { set(&t.connectStart, true) },
+		ConnectDone:          func(string, string, error) { set(&t.connectEnd, false) },
+		TLSHandshakeStart:    func() { set(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&t.tlsDone, false) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&t.wrote, false) },
+		GotFirstResponseByte: func() { set(&t.firstByte, false) },
+	})
+}
+
+// timing computes phase durations, phases that did not happen stay zero
+func (t *phaseTracer) timing(headers time.Time) timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := func(from, to time.Time) time.Duration {
+		if from.IsZero() || to.IsZero() {
+			return 0
+		}
+		return to.Sub(from)
+	}
+	return timing{
+		DNS:     span(t.dnsStart, t.dnsDone),
+		Connect: span(t.connectStart, t.connectEnd),
+		TLS:     span(t.tlsStart, t.tlsDone),
+		TTFB:    span(t.wrote, t.firstByte),
+		Total:   headers.Sub(t.start),
+	}
+}
+
+// tracedGet sends GET request to url with probeClient and returns response along with its timing
+func tracedGet(ctx context.Context, url string) (*http.Response, timing, error) {
+	tracer := &phaseTracer{}
+	req, err := http.NewRequestWithContext(tracer.trace(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, timing{}, err
+	}
+	tracer.start = time.Now()
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return nil, timing{}, err
+	}
+	return resp, tracer.timing(time.Now()), nil
+}
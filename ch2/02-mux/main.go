@@ -1,16 +1,50 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
 	// compiler would complain on undeclared name if we won't run all go files
-	var m mux
+	m := newMux()
+	m.get("/", random)
+	m.get("/random/bytes", bytesHandler)
+	m.get("/random/int", intHandler)
+	m.get("/random/:dist", distributionHandler)
+
+	api := m.sub("/api", logging)
+	api.get("/users/:id", user)
+	api.put("/users/:id", user)
+	api.get("/users/:id/posts/:post", post)
+	api.get("/files/*path", file)
+
 	log.Fatal(http.ListenAndServe("localhost:8080", m))
 }
 
+// logging prints method, path and duration of requests
+func logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+	})
+}
+
+func user(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "%s user %s\n", r.Method, pathParam(r, "id"))
+}
+
+func post(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "post %s of user %s\n", pathParam(r, "post"), pathParam(r, "id"))
+}
+
+func file(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "file %q\n", pathParam(r, "path"))
+}
+
 /*
 $ go run *.go
 
@@ -21,4 +55,56 @@ Content-Length: 10
 Content-Type: application/octet-stream
 
 We3VAw��
+
+# random bytes come from crypto/rand unless seeded, encoding is hex, base64, base32, uuid or ulid
+$ curl 'localhost:8080/random/bytes?n=8&encoding=base64&count=2'
+{"source":"crypto","values":["p3N0zVq1Tgo","6JvJc5mKZ-0"]}
+$ curl 'localhost:8080/random/bytes?encoding=uuid'
+{"source":"crypto","values":["0b5cf7d4-2b51-4c4e-9f3e-c1a8a1f2d0e3"]}
+
+# integers and samples of uniform, normal, exponential and poisson distributions come from math/rand,
+# the same seed gives the same values
+$ curl 'localhost:8080/random/int?min=1&max=6&count=5&seed=42'
+{"source":"math","seed":42,"values":[2,2,1,6,6]}
+$ curl 'localhost:8080/random/normal?mean=100&stddev=15&count=3&seed=7'
+{"source":"math","seed":7,"values":[96.40128896744717,113.68279117145448,113.8913042800873]}
+$ curl 'localhost:8080/random/poisson?lambda=3&count=5&source=crypto'
+{"source":"crypto","values":[2,3,5,1,3]}
+
+# named parameters and wildcards
+$ curl localhost:8080/api/users/42/posts/7
+post 7 of user 42
+$ curl localhost:8080/api/files/docs/readme.md
+file "docs/readme.md"
+
+# wrong method gets 405 with the methods the path has, OPTIONS lists them too
+$ curl -i -X DELETE localhost:8080/api/users/42
+HTTP/1.1 405 Method Not Allowed
+Allow: GET, HEAD, OPTIONS, PUT
+Content-Type: text/plain; charset=utf-8
+X-Content-Type-Options: nosniff
+Date: Thu, 18 Feb 2021 10:50:12 GMT
+Content-Length: 25
+
+405 - Method Not Allowed
+$ curl -i -X OPTIONS localhost:8080/api/users/42
+HTTP/1.1 204 No Content
+Allow: GET, HEAD, OPTIONS, PUT
+Date: Thu, 18 Feb 2021 10:50:20 GMT
+
+# HEAD is served by GET routes
+$ curl -I localhost:8080/api/users/42
+HTTP/1.1 200 OK
+Content-Length: 12
+Content-Type: text/plain; charset=utf-8
+Date: Thu, 18 Feb 2021 10:50:31 GMT
+
+# routing tests and benchmarks against httprouter on the same routes
+$ go test -bench . -benchmem
+BenchmarkMux_Static          	 3501862	        73.55 ns/op	       0 B/op	       0 allocs/op
+BenchmarkMux_Param           	  326516	       876.9 ns/op	     509 B/op	       4 allocs/op
+BenchmarkMux_Wildcard        	  471934	       559.4 ns/op	     424 B/op	       4 allocs/op
+BenchmarkHttprouter_Static   	 3248523	        69.34 ns/op	       0 B/op	       0 allocs/op
+BenchmarkHttprouter_Param    	 1000000	       218.6 ns/op	      74 B/op	       1 allocs/op
+BenchmarkHttprouter_Wildcard 	 2212633	       141.4 ns/op	      32 B/op	       1 allocs/op
 */
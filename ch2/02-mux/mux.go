@@ -1,29 +1,290 @@
 package main
 
 import (
-	"crypto/rand"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"sort"
+	"strings"
 )
 
-// mux is a custom multiplexer
-type mux struct{}
+// mux is a custom multiplexer, routes of every method are kept in their own radix tree.
+// patterns are made of static parts, named parameters like /users/:id matching a single segment
+// and a trailing wildcard like /files/*path matching the rest of the path.
+type mux struct {
+	group
+	trees map[string]*node
+}
+
+// middleware wraps a handler
+type middleware func(http.Handler) http.Handler
+
+// group registers routes under a common prefix wrapping them with its middleware
+type group struct {
+	m          *mux
+	prefix     string
+	middleware []middleware
+}
+
+// newMux creates an empty mux, its root group has no prefix nor middleware
+func newMux() *mux {
+	m := &mux{trees: make(map[string]*node)}
+	m.group.m = m
+	return m
+}
+
+// use adds middleware to the group, it wraps routes registered after the call
+func (g *group) use(mw ...middleware) {
+	g.middleware = append(g.middleware, mw...)
+}
+
+// sub creates a group nested under this one, it inherits middleware of the parent
+func (g *group) sub(prefix string, mw ...middleware) *group {
+	return &group{
+		m:          g.m,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: append(append([]middleware(nil), g.middleware...), mw...),
+	}
+}
+
+// handle registers handler for method and pattern, it panics on invalid or conflicting patterns
+func (g *group) handle(method, pattern string, handler http.Handler) {
+	pattern = g.prefix + pattern
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("mux: pattern %q must start with '/'", pattern))
+	}
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	root, ok := g.m.trees[method]
+	if !ok {
+		root = &node{}
+		g.m.trees[method] = root
+	}
+	root.insert(pattern, pattern, handler)
+}
 
-func (m mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/" {
-		random(w, r)
+func (g *group) get(pattern string, f http.HandlerFunc)    { g.handle(http.MethodGet, pattern, f) }
+func (g *group) post(pattern string, f http.HandlerFunc)   { g.handle(http.MethodPost, pattern, f) }
+func (g *group) put(pattern string, f http.HandlerFunc)    { g.handle(http.MethodPut, pattern, f) }
+func (g *group) patch(pattern string, f http.HandlerFunc)  { g.handle(http.MethodPatch, pattern, f) }
+func (g *group) delete(pattern string, f http.HandlerFunc) { g.handle(http.MethodDelete, pattern, f) }
+
+// ServeHTTP dispatches by method and path. HEAD falls back to GET routes, OPTIONS and
+// requests with a method the path has no route for are answered with the Allow header.
+func (m *mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if root := m.trees[r.Method]; root != nil {
+		if n, ps := root.lookup(path, nil); n != nil {
+			serve(w, r, n.handler, ps)
+			return
+		}
+	}
+	if r.Method == http.MethodHead {
+		if root := m.trees[http.MethodGet]; root != nil {
+			if n, ps := root.lookup(path, nil); n != nil {
+				serve(w, r, n.handler, ps)
+				return
+			}
+		}
+	}
+
+	allowed := m.allowed(path)
+	if len(allowed) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	http.NotFound(w, r)
-	return
+	http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+}
+
+// allowed returns methods having a route for the path, "*" asks for every registered method
+func (m *mux) allowed(path string) []string {
+	var methods []string
+	for method, root := range m.trees {
+		if path == "*" {
+			methods = append(methods, method)
+		} else if n, _ := root.lookup(path, nil); n != nil {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+	has := make(map[string]bool)
+	for _, method := range methods {
+		has[method] = true
+	}
+	if has[http.MethodGet] && !has[http.MethodHead] {
+		methods = append(methods, http.MethodHead)
+	}
+	if !has[http.MethodOptions] {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// serve calls handler with path parameters put into the request context
+func serve(w http.ResponseWriter, r *http.Request, handler http.Handler, ps params) {
+	if len(ps) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, ps))
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// param is a single named path parameter
+type param struct {
+	key   string
+	value string
+}
+
+// params are path parameters of a matched route in order they appear in the pattern
+type params []param
+
+// paramsKey is the context key of path parameters
+type paramsKey struct{}
+
+// pathParam returns value of the named path parameter of the request, empty if there's none
+func pathParam(r *http.Request, name string) string {
+	ps, _ := r.Context().Value(paramsKey{}).(params)
+	for _, p := range ps {
+		if p.key == name {
+			return p.value
+		}
+	}
+	return ""
+}
+
+// node is a radix tree node. static children are split on common prefixes,
+// parameter and wildcard children are tried only when no static child matches.
+type node struct {
+	prefix   string
+	children []*node // static, first bytes of their prefixes are distinct
+	param    *node   // :name child, matches up to the next slash
+	wildcard *node   // *name child, matches the rest of the path
+	name     string  // of the parameter or wildcard node
+	handler  http.Handler
+	pattern  string // route of the handler, used in conflict messages
+}
+
+// insert adds the route below n, path is what is left of the pattern
+func (n *node) insert(path, pattern string, handler http.Handler) {
+	for {
+		if path == "" {
+			if n.handler != nil {
+				panic(fmt.Sprintf("mux: %q conflicts with %q", pattern, n.pattern))
+			}
+			n.handler, n.pattern = handler, pattern
+			return
+		}
+
+		switch path[0] {
+		case ':':
+			end := strings.IndexByte(path, '/')
+			if end < 0 {
+				end = len(path)
+			}
+			name := path[1:end]
+			if name == "" || strings.ContainsAny(name, ":*") {
+				panic(fmt.Sprintf("mux: bad parameter in %q", pattern))
+			}
+			if n.param == nil {
+				n.param = &node{name: name}
+			} else if n.param.name != name {
+				panic(fmt.Sprintf("mux: parameter :%s in %q conflicts with :%s", name, pattern, n.param.name))
+			}
+			n, path = n.param, path[end:]
+
+		case '*':
+			name := path[1:]
+			if name == "" || strings.ContainsAny(name, "/:*") {
+				panic(fmt.Sprintf("mux: wildcard must be named and end the pattern %q", pattern))
+			}
+			if n.wildcard != nil {
+				panic(fmt.Sprintf("mux: %q conflicts with %q", pattern, n.wildcard.pattern))
+			}
+			n.wildcard = &node{name: name, handler: handler, pattern: pattern}
+			return
+
+		default:
+			end := strings.IndexAny(path, ":*")
+			if end < 0 {
+				end = len(path)
+			}
+			if end < len(path) && path[end-1] != '/' {
+				panic(fmt.Sprintf("mux: parameters and wildcards must follow '/' in %q", pattern))
+			}
+			static := path[:end]
+			child := n.staticChild(static[0])
+			if child == nil {
+				child = &node{prefix: static}
+				n.children = append(n.children, child)
+			}
+			common := commonPrefix(child.prefix, static)
+			if common < len(child.prefix) {
+				// split the child, its tail moves one level down
+				tail := *child
+				tail.prefix = child.prefix[common:]
+				*child = node{prefix: child.prefix[:common], children: []*node{&tail}}
+			}
+			n, path = child, path[common:]
+		}
+	}
+}
+
+// staticChild returns child whose prefix starts with c
+func (n *node) staticChild(c byte) *node {
+	for _, child := range n.children {
+		if child.prefix[0] == c {
+			return child
+		}
+	}
+	return nil
+}
+
+// commonPrefix returns length of the common prefix of a and b
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
 }
 
-func random(w http.ResponseWriter, r *http.Request) {
-	bs := make([]byte, 10)
-	_, err := rand.Read(bs)
-	if err != nil {
-		log.Fatalln(err)
+// lookup finds the node handling path, static routes win over parameters and parameters over wildcards
+func (n *node) lookup(path string, ps params) (*node, params) {
+	if path == "" {
+		if n.handler != nil {
+			return n, ps
+		}
+		if n.wildcard != nil {
+			return n.wildcard, append(ps, param{n.wildcard.name, ""})
+		}
+		return nil, nil
+	}
+	if child := n.staticChild(path[0]); child != nil && strings.HasPrefix(path, child.prefix) {
+		if found, fps := child.lookup(path[len(child.prefix):], ps); found != nil {
+			return found, fps
+		}
+	}
+	if n.param != nil {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if end > 0 {
+			if found, fps := n.param.lookup(path[end:], append(ps, param{n.param.name, path[:end]})); found != nil {
+				return found, fps
+			}
+		}
+	}
+	if n.wildcard != nil {
+		return n.wildcard, append(ps, param{n.wildcard.name, path})
 	}
-	fmt.Fprint(w, fmt.Sprintf("%x", bs))
+	return nil, nil
 }
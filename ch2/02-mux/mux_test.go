@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// echo writes the route name followed by the requested path parameters
+func echo(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out := []string{name}
+		for _, p := range params {
+			out = append(out, pathParam(r, p))
+		}
+		w.Write([]byte(strings.Join(out, "|")))
+	}
+}
+
+func TestMuxRouting(t *testing.T) {
+	m := newMux()
+	m.get("/", echo("root"))
+	m.get("/users", echo("users"))
+	m.get("/users/new", echo("new"))
+	m.get("/users/:id", echo("user", "id"))
+	m.get("/users/:id/posts/:post", echo("post", "id", "post"))
+	m.get("/static/*path", echo("static", "path"))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", http.StatusOK, "root"},
+		{"/users", http.StatusOK, "users"},
+		{"/users/new", http.StatusOK, "new"}, // static wins over the parameter
+		{"/users/42", http.StatusOK, "user|42"},
+		{"/users/42/posts/7", http.StatusOK, "post|42|7"},
+		{"/static/", http.StatusOK, "static|"},
+		{"/static/js/app.js", http.StatusOK, "static|js/app.js"},
+		{"/users/42/posts", http.StatusNotFound, ""},
+		{"/userz", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestMuxMethods(t *testing.T) {
+	m := newMux()
+	m.get("/users", echo("list"))
+	m.post("/users", echo("create"))
+	m.delete("/users/:id", echo("remove", "id"))
+	m.handle(http.MethodOptions, "/custom", echo("options"))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		allow  string
+		body   string
+	}{
+		{"route of the method", http.MethodPost, "/users", http.StatusOK, "", "create"},
+		{"head falls back to get", http.MethodHead, "/users", http.StatusOK, "", ""},
+		{"method not allowed", http.MethodPut, "/users", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST", ""},
+		{"no get no head", http.MethodGet, "/users/1", http.StatusMethodNotAllowed, "DELETE, OPTIONS", ""},
+		{"automatic options", http.MethodOptions, "/users", http.StatusNoContent, "GET, HEAD, OPTIONS, POST", ""},
+		{"registered options", http.MethodOptions, "/custom", http.StatusOK, "", "options"},
+		{"options of the server", http.MethodOptions, "*", http.StatusNoContent, "DELETE, GET, HEAD, OPTIONS, POST", ""},
+		{"unknown path", http.MethodPut, "/nowhere", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.URL.Path = tt.path // "*" isn't a valid target for NewRequest
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestMuxMiddlewareOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	m := newMux()
+	m.use(mark("root"))
+	m.get("/plain", echo("plain"))
+	api := m.sub("/api", mark("api"))
+	api.use(mark("api-late"))
+	api.get("/users", echo("users"))
+	v1 := api.sub("/v1/", mark("v1"))
+	v1.get("/items", echo("items"))
+	m.use(mark("root-late")) // only routes registered from now on get it
+	m.get("/late", echo("late"))
+
+	tests := []struct {
+		path  string
+		calls []string
+	}{
+		{"/plain", []string{"root"}},
+		{"/api/users", []string{"root", "api", "api-late"}},
+		{"/api/v1/items", []string{"root", "api", "api-late", "v1"}},
+		{"/late", []string{"root", "root-late"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			calls = nil
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if strings.Join(calls, ",") != strings.Join(tt.calls, ",") {
+				t.Errorf("middleware calls = %v, want %v", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestMuxConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		pattern  string
+	}{
+		{"duplicate", "/users", "/users"},
+		{"parameter names differ", "/users/:id", "/users/:uid"},
+		{"wildcard not last", "", "/files/*path/x"},
+		{"empty parameter name", "", "/a/:"},
+		{"no leading slash", "", "users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMux()
+			if tt.existing != "" {
+				m.get(tt.existing, echo("x"))
+			}
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q didn't panic", tt.pattern)
+				}
+			}()
+			m.get(tt.pattern, echo("y"))
+		})
+	}
+}
+
+// benchRoutes are registered in both routers, benchmarks request paths of one kind at a time
+var benchRoutes = []string{
+	"/",
+	"/users",
+	"/users/:id",
+	"/users/:id/posts/:post",
+	"/repos/:owner/:repo/issues/:number/comments",
+	"/static/*path",
+	"/a/b/c/d/e",
+	"/a/b/c/x",
+}
+
+var benchPaths = map[string][]string{
+	"Static":   {"/users", "/a/b/c/d/e", "/a/b/c/x"},
+	"Param":    {"/users/42", "/users/42/posts/7", "/repos/golang/go/issues/1/comments"},
+	"Wildcard": {"/static/js/app.js", "/static/css/site/main.css"},
+}
+
+func benchmarkRouter(b *testing.B, router http.Handler, paths []string) {
+	reqs := make([]*http.Request, len(paths))
+	for i, p := range paths {
+		reqs[i] = httptest.NewRequest(http.MethodGet, p, nil)
+	}
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, reqs[i%len(reqs)])
+	}
+}
+
+func newBenchMux() http.Handler {
+	m := newMux()
+	for _, route := range benchRoutes {
+		m.get(route, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	return m
+}
+
+func newBenchHttprouter() http.Handler {
+	router := httprouter.New()
+	for _, route := range benchRoutes {
+		router.GET(route, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+	}
+	return router
+}
+
+func BenchmarkMux_Static(b *testing.B)   { benchmarkRouter(b, newBenchMux(), benchPaths["Static"]) }
+func BenchmarkMux_Param(b *testing.B)    { benchmarkRouter(b, newBenchMux(), benchPaths["Param"]) }
+func BenchmarkMux_Wildcard(b *testing.B) { benchmarkRouter(b, newBenchMux(), benchPaths["Wildcard"]) }
+
+func BenchmarkHttprouter_Static(b *testing.B) {
+	benchmarkRouter(b, newBenchHttprouter(), benchPaths["Static"])
+}
+func BenchmarkHttprouter_Param(b *testing.B) {
+	benchmarkRouter(b, newBenchHttprouter(), benchPaths["Param"])
+}
+func BenchmarkHttprouter_Wildcard(b *testing.B) {
+	benchmarkRouter(b, newBenchHttprouter(), benchPaths["Wildcard"])
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// random query limits
+const (
+	maxBytes  = 4096
+	maxCount  = 1000
+	maxLambda = 1e6
+)
+
+// random sources
+const (
+	sourceCrypto = "crypto" // crypto/rand, for tokens, can't be seeded
+	sourceMath   = "math"   // math/rand, reproducible with seed
+)
+
+// randomResponse responce struct, seed is set for seeded math source only
+type randomResponse struct {
+	Source string        `json:"source"`
+	Seed   *int64        `json:"seed,omitempty"`
+	Values []interface{} `json:"values"`
+}
+
+// errorResponse is returned on bad requests and failures
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON encodes v as json response with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// generator draws random values from the source selected by the request
+type generator struct {
+	source string
+	seed   *int64
+	rnd    *rand.Rand
+	crypto *cryptoSource // nil for math source
+}
+
+// newGenerator reads source and seed query parameters, seed implies math source
+func newGenerator(r *http.Request, defaultSource string) (*generator, error) {
+	q := r.URL.Query()
+	g := &generator{source: q.Get("source")}
+	if value := q.Get("seed"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("seed must be an integer")
+		}
+		if g.source == sourceCrypto {
+			return nil, fmt.Errorf("crypto source can't be seeded")
+		}
+		g.source, g.seed = sourceMath, &seed
+	}
+	if g.source == "" {
+		g.source = defaultSource
+	}
+
+	switch g.source {
+	case sourceCrypto:
+		g.crypto = &cryptoSource{}
+		g.rnd = rand.New(g.crypto)
+	case sourceMath:
+		seed := time.Now().UnixNano()
+		if g.seed != nil {
+			seed = *g.seed
+		}
+		g.rnd = rand.New(rand.NewSource(seed))
+	default:
+		return nil, fmt.Errorf("source must be %s or %s", sourceCrypto, sourceMath)
+	}
+	return g, nil
+}
+
+// read fills b with random bytes
+func (g *generator) read(b []byte) error {
+	if g.crypto != nil {
+		_, err := crand.Read(b)
+		return err
+	}
+	g.rnd.Read(b)
+	return nil
+}
+
+// err reports failure of the crypto source, values drawn after a failure are not random
+func (g *generator) err() error {
+	if g.crypto != nil {
+		return g.crypto.err
+	}
+	return nil
+}
+
+// response wraps values drawn by the generator
+func (g *generator) response(values []interface{}) randomResponse {
+	return randomResponse{g.source, g.seed, values}
+}
+
+// cryptoSource is a math/rand source reading crypto/rand, the first read error is kept
+type cryptoSource struct {
+	err error
+}
+
+func (s *cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil && s.err == nil {
+		s.err = err
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (s *cryptoSource) Int63() int64 { return int64(s.Uint64() >> 1) }
+
+func (s *cryptoSource) Seed(int64) {}
+
+// random returns 10 random bytes as hex, kept for the root route
+func random(w http.ResponseWriter, r *http.Request) {
+	bs := make([]byte, 10)
+	if _, err := crand.Read(bs); err != nil {
+		http.Error(w, "500 - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%x", bs)
+}
+
+// encoders turn random bytes into strings, uuid and ulid take fixed sizes
+var encoders = map[string]func(g *generator, n int) (string, error){
+	"hex":    encodeBytes(hex.EncodeToString),
+	"base64": encodeBytes(base64.RawURLEncoding.EncodeToString),
+	"base32": encodeBytes(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString),
+	"uuid":   uuidV4,
+	"ulid":   ulid,
+}
+
+// encodeBytes draws n bytes and encodes them
+func encodeBytes(encode func([]byte) string) func(g *generator, n int) (string, error) {
+	return func(g *generator, n int) (string, error) {
+		b := make([]byte, n)
+		if err := g.read(b); err != nil {
+			return "", err
+		}
+		return encode(b), nil
+	}
+}
+
+// uuidV4 returns random uuid as defined in RFC 4122 section 4.4
+func uuidV4(g *generator, _ int) (string, error) {
+	var b [16]byte
+	if err := g.read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// crockford is the ulid alphabet
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid returns 48 bits of unix milliseconds followed by 80 random bits in Crockford base32.
+// the time part is the current time even with a seed.
+func ulid(g *generator, _ int) (string, error) {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	if err := g.read(b[6:]); err != nil {
+		return "", err
+	}
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	// 128 bits as 26 characters of 5 bits, the first one holds the 3 topmost bits
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
+
+// bytesHandler returns count random values of n bytes in the chosen encoding, crypto source by default
+func bytesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	encoding := q.Get("encoding")
+	if encoding == "" {
+		encoding = "hex"
+	}
+	encode, ok := encoders[encoding]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"encoding must be one of hex, base64, base32, uuid, ulid"})
+		return
+	}
+	n, err := queryInt(r, "n", 16, 1, maxBytes)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	count, err := queryInt(r, "count", 1, 1, maxCount)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	g, err := newGenerator(r, sourceCrypto)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		if values[i], err = encode(g, int(n)); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, g.response(values))
+}
+
+// intHandler returns count integers in [min, max], math source by default
+func intHandler(w http.ResponseWriter, r *http.Request) {
+	min, err := queryInt(r, "min", 0, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	max, err := queryInt(r, "max", 999, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	// Int63n takes the width of the range, it has to fit into int63
+	if max < min || uint64(max)-uint64(min) >= math.MaxInt64 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"min must not exceed max and the range must be narrower than 2^63"})
+		return
+	}
+	count, err := queryInt(r, "count", 1, 1, maxCount)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	g, err := newGenerator(r, sourceMath)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		values[i] = min + g.rnd.Int63n(max-min+1)
+	}
+	if err := g.err(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, g.response(values))
+}
+
+// distributions draw a single sample with parameters read from the query
+var distributions = map[string]func(r *http.Request) (func(*rand.Rand) interface{}, error){
+	"uniform": func(r *http.Request) (func(*rand.Rand) interface{}, error) {
+		min, err := queryFloat(r, "min", 0)
+		if err != nil {
+			return nil, err
+		}
+		max, err := queryFloat(r, "max", 1)
+		if err != nil {
+			return nil, err
+		}
+		if max <= min {
+			return nil, fmt.Errorf("max must be greater than min")
+		}
+		return func(rnd *rand.Rand) interface{} { return min + rnd.Float64()*(max-min) }, nil
+	},
+	"normal": func(r *http.Request) (func(*rand.Rand) interface{}, error) {
+		mean, err := queryFloat(r, "mean", 0)
+		if err != nil {
+			return nil, err
+		}
+		stddev, err := queryFloat(r, "stddev", 1)
+		if err != nil {
+			return nil, err
+		}
+		if stddev <= 0 {
+			return nil, fmt.Errorf("stddev must be positive")
+		}
+		return func(rnd *rand.Rand) interface{} { return mean + rnd.NormFloat64()*stddev }, nil
+	},
+	"exponential": func(r *http.Request) (func(*rand.Rand) interface{}, error) {
+		rate, err := queryFloat(r, "rate", 1)
+		if err != nil {
+			return nil, err
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("rate must be positive")
+		}
+		return func(rnd *rand.Rand) interface{} { return rnd.ExpFloat64() / rate }, nil
+	},
+	"poisson": func(r *http.Request) (func(*rand.Rand) interface{}, error) {
+		lambda, err := queryFloat(r, "lambda", 1)
+		if err != nil {
+			return nil, err
+		}
+		if lambda <= 0 || lambda > maxLambda {
+			return nil, fmt.Errorf("lambda must be in (0, %g]", float64(maxLambda))
+		}
+		return func(rnd *rand.Rand) interface{} { return poisson(rnd, lambda) }, nil
+	},
+}
+
+// poisson uses Knuth's multiplication method for small lambda and Hörmann's transformed rejection
+// with squeeze (PTRS) above it, both are exact while the work of the latter doesn't grow with lambda
+func poisson(rnd *rand.Rand, lambda float64) int64 {
+	if lambda >= 10 {
+		return poissonPTRS(rnd, lambda)
+	}
+	limit, p, k := math.Exp(-lambda), 1.0, int64(0)
+	for {
+		p *= rnd.Float64()
+		if p <= limit {
+			return k
+		}
+		k++
+	}
+}
+
+// poissonPTRS samples poisson distribution with lambda >= 10,
+// see W. Hörmann, The transformed rejection method for generating Poisson random variables (1993)
+func poissonPTRS(rnd *rand.Rand, lambda float64) int64 {
+	logLambda := math.Log(lambda)
+	b := 0.931 + 2.53*math.Sqrt(lambda)
+	a := -0.059 + 0.02483*b
+	invAlpha := 1.1239 + 1.1328/(b-3.4)
+	vr := 0.9277 - 3.6224/(b-2)
+	for {
+		u := rnd.Float64() - 0.5
+		v := rnd.Float64()
+		us := 0.5 - math.Abs(u)
+		k := math.Floor((2*a/us+b)*u + lambda + 0.43)
+		// squeeze, most samples are accepted without logarithms
+		if us >= 0.07 && v <= vr {
+			return int64(k)
+		}
+		if k < 0 || (us < 0.013 && v > us) {
+			continue
+		}
+		lg, _ := math.Lgamma(k + 1)
+		if math.Log(v)+math.Log(invAlpha)-math.Log(a/(us*us)+b) <= -lambda+k*logLambda-lg {
+			return int64(k)
+		}
+	}
+}
+
+// distributionHandler returns count samples of /random/:dist, math source by default
+func distributionHandler(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "dist")
+	dist, ok := distributions[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{fmt.Sprintf("unknown distribution %q, use uniform, normal, exponential or poisson", name)})
+		return
+	}
+	sample, err := dist(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	count, err := queryInt(r, "count", 1, 1, maxCount)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	g, err := newGenerator(r, sourceMath)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		values[i] = sample(g.rnd)
+	}
+	if err := g.err(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, g.response(values))
+}
+
+// queryInt parses optional integer query parameter and checks it is within [min, max]
+func queryInt(r *http.Request, name string, def, min, max int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// queryFloat parses optional finite float query parameter
+func queryFloat(r *http.Request, name string, def float64) (float64, error) {
+	value := strings.TrimSpace(r.URL.Query().Get(name))
+	if value == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	return f, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestPoisson(t *testing.T) {
+	const n = 200000
+	rnd := rand.New(rand.NewSource(1))
+	// both methods and the switch between them, up to maxLambda
+	for _, lambda := range []float64{0.5, 4, 9.9, 10, 31, 50, 1000, maxLambda} {
+		samples := make([]float64, n)
+		var mean float64
+		for i := range samples {
+			k := poisson(rnd, lambda)
+			if k < 0 {
+				t.Fatalf("lambda %g: negative sample %d", lambda, k)
+			}
+			samples[i] = float64(k)
+			mean += samples[i]
+		}
+		mean /= n
+		var m2, m3 float64
+		for _, x := range samples {
+			d := x - mean
+			m2 += d * d
+			m3 += d * d * d
+		}
+		m2 /= n
+		m3 /= n
+
+		// mean, variance and third central moment of poisson all equal lambda,
+		// a rounded normal would pass the first two but has no skew
+		if tol := 5 * math.Sqrt(lambda/n); math.Abs(mean-lambda) > tol {
+			t.Errorf("lambda %g: mean %g, want within %g", lambda, mean, tol)
+		}
+		if tol := 5 * math.Sqrt((2*lambda*lambda+lambda)/n); math.Abs(m2-lambda) > tol {
+			t.Errorf("lambda %g: variance %g, want within %g", lambda, m2, tol)
+		}
+		if tol := 5 * math.Sqrt((15*lambda*lambda*lambda+25*lambda*lambda+lambda)/n); math.Abs(m3-lambda) > tol {
+			t.Errorf("lambda %g: third moment %g, want within %g", lambda, m3, tol)
+		}
+	}
+}
+
+func TestPoissonProbabilities(t *testing.T) {
+	const n = 200000
+	rnd := rand.New(rand.NewSource(2))
+	for _, lambda := range []float64{20, 50} {
+		counts := make(map[int64]int)
+		for i := 0; i < n; i++ {
+			counts[poisson(rnd, lambda)]++
+		}
+		// frequencies of the values around the mode match the probability mass function
+		for k := int64(lambda * 0.6); k <= int64(lambda*1.4); k++ {
+			lg, _ := math.Lgamma(float64(k) + 1)
+			p := math.Exp(-lambda + float64(k)*math.Log(lambda) - lg)
+			got := float64(counts[k]) / n
+			if tol := 5 * math.Sqrt(p*(1-p)/n); math.Abs(got-p) > tol {
+				t.Errorf("lambda %g: P(%d) = %.5f, want %.5f within %.5f", lambda, k, got, p, tol)
+			}
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// command execution limits (set with command line flags)
+var (
+	execTimeout   time.Duration
+	maxOutput     int64
+	maxConcurrent int
+	auditPath     string
+)
+
+func init() {
+	flag.DurationVar(&execTimeout, "exec-timeout", 10*time.Second, "default deadline of a command, the process is killed after it")
+	flag.Int64Var(&maxOutput, "max-output", 1<<20, "max bytes kept of stdout and of stderr each, the rest is dropped")
+	flag.IntVar(&maxConcurrent, "max-concurrent", 4, "max commands running at once, requests over it get 429")
+	flag.StringVar(&auditPath, "audit-log", "", "file commands are audit logged to (default stderr)")
+}
+
+// argSpec describes a single query parameter of a command, the value must match pattern or be one of enum
+type argSpec struct {
+	Name     string         `json:"name"`
+	Pattern  *regexp.Regexp `json:"-"`
+	Enum     []string       `json:"enum,omitempty"`
+	Default  string         `json:"default,omitempty"`
+	Required bool           `json:"required"`
+	Path     bool           `json:"path,omitempty"` // value must also be a clean absolute path, no .. tricks
+}
+
+// command is an allowlisted binary. argv tokens like {name} are replaced by validated argument values as
+// a whole, values never get concatenated into other tokens and are never parsed by a shell.
+type command struct {
+	Name       string        `json:"name"`
+	Binary     string        `json:"binary"`
+	Argv       []string      `json:"argv"`
+	Args       []argSpec     `json:"args,omitempty"`
+	Timeout    time.Duration `json:"timeout,omitempty"` // execTimeout if zero
+	StreamOnly bool          `json:"stream_only,omitempty"`
+}
+
+// pathPattern matches absolute paths that can't be taken for options, used together with Path
+var pathPattern = regexp.MustCompile(`^/[A-Za-z0-9._/ -]*$`)
+
+// commands is the allowlist, nothing else can be run
+var commands = map[string]*command{
+	"uptime": {Name: "uptime", Binary: "uptime"},
+	"df": {
+		Name: "df", Binary: "df", Argv: []string{"-h", "--", "{mount}"},
+		Args: []argSpec{{Name: "mount", Pattern: pathPattern, Path: true, Default: "/"}},
+	},
+	"du": {
+		Name: "du", Binary: "du", Argv: []string{"-s", "{unit}", "--", "{path}"}, Timeout: time.Minute,
+		Args: []argSpec{
+			{Name: "path", Pattern: pathPattern, Path: true, Required: true},
+			{Name: "unit", Enum: []string{"-h", "-k", "-m"}, Default: "-h"},
+		},
+	},
+	"tail": {
+		Name: "tail", Binary: "tail", Argv: []string{"-n", "{lines}", "--", "{file}"},
+		Args: []argSpec{
+			{Name: "file", Pattern: regexp.MustCompile(`^/var/log/[A-Za-z0-9._/-]+$`), Path: true, Required: true},
+			{Name: "lines", Pattern: regexp.MustCompile(`^[1-9][0-9]{0,3}$`), Default: "10"},
+		},
+	},
+	"follow": {
+		Name: "follow", Binary: "tail", Argv: []string{"-n", "{lines}", "-F", "--", "{file}"}, Timeout: time.Hour, StreamOnly: true,
+		Args: []argSpec{
+			{Name: "file", Pattern: regexp.MustCompile(`^/var/log/[A-Za-z0-9._/-]+$`), Path: true, Required: true},
+			{Name: "lines", Pattern: regexp.MustCompile(`^[0-9]{1,4}$`), Default: "10"},
+		},
+	},
+}
+
+// argv validates query values against the argument schema and builds the argument list
+func (c *command) argv(values map[string][]string) ([]string, error) {
+	known := make(map[string]bool)
+	args := make(map[string]string)
+	for _, spec := range c.Args {
+		known[spec.Name] = true
+		vs := values[spec.Name]
+		if len(vs) > 1 {
+			return nil, fmt.Errorf("%s given more than once", spec.Name)
+		}
+		value := spec.Default
+		if len(vs) == 1 {
+			value = vs[0]
+		}
+		if value == "" {
+			if spec.Required {
+				return nil, fmt.Errorf("%s is required", spec.Name)
+			}
+			continue
+		}
+		if !spec.allows(value) {
+			return nil, fmt.Errorf("%s value %q is not allowed", spec.Name, value)
+		}
+		args[spec.Name] = value
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown argument %s", name)
+		}
+	}
+
+	argv := []string{}
+	for _, token := range c.Argv {
+		if strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}") {
+			value, ok := args[strings.Trim(token, "{}")]
+			if !ok {
+				continue // optional argument left out
+			}
+			token = value
+		}
+		argv = append(argv, token)
+	}
+	return argv, nil
+}
+
+// allows checks the value against enum or pattern of the spec
+func (spec argSpec) allows(value string) bool {
+	if spec.Path && (!strings.HasPrefix(value, "/") || path.Clean(value) != value) {
+		return false
+	}
+	if len(spec.Enum) > 0 {
+		for _, v := range spec.Enum {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+	return spec.Pattern != nil && spec.Pattern.MatchString(value)
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest,
+// it never fails writes so the process isn't killed by a broken pipe
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// execResult responce struct
+type execResult struct {
+	Command   string        `json:"command"`
+	Args      []string      `json:"args"`
+	ExitCode  int           `json:"exit_code"` // -1 if the process didn't exit on its own
+	Stdout    string        `json:"stdout"`
+	Stderr    string        `json:"stderr"`
+	Truncated bool          `json:"truncated,omitempty"`
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// slots limits commands running at once, set up in setupCommands once flags are parsed
+var slots chan struct{}
+
+// audit logs every invocation including rejected ones
+var audit = log.New(os.Stderr, "audit: ", log.LstdFlags|log.LUTC)
+
+// setupCommands applies limits and opens audit log
+func setupCommands() error {
+	if maxConcurrent < 1 {
+		return fmt.Errorf("max-concurrent must be positive")
+	}
+	slots = make(chan struct{}, maxConcurrent)
+	if auditPath != "" {
+		f, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		audit.SetOutput(f)
+	}
+	return nil
+}
+
+// errBusy is returned when all command slots are taken
+var errBusy = errors.New("too many commands running, try again later")
+
+// acquire takes a command slot without waiting
+func acquire() (release func(), err error) {
+	select {
+	case slots <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-slots }) }, nil
+	default:
+		return nil, errBusy
+	}
+}
+
+// prepare looks the command up and validates the request arguments, status tells what went wrong
+func prepare(r *http.Request, name string, streaming bool) (*command, []string, int, error) {
+	c, ok := commands[name]
+	if !ok {
+		return nil, nil, http.StatusNotFound, fmt.Errorf("unknown command %q", name)
+	}
+	if c.StreamOnly && !streaming {
+		return c, nil, http.StatusBadRequest, fmt.Errorf("%s never ends on its own, use /stream/%s", name, name)
+	}
+	argv, err := c.argv(r.URL.Query())
+	if err != nil {
+		return c, nil, http.StatusBadRequest, err
+	}
+	return c, argv, http.StatusOK, nil
+}
+
+// timeout returns deadline of the command
+func (c *command) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return execTimeout
+}
+
+// run executes the command with its deadline and output limits, status is the http status of the result
+func (c *command) run(ctx context.Context, argv []string) (execResult, int) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	stdout, stderr := &limitedBuffer{max: maxOutput}, &limitedBuffer{max: maxOutput}
+	cmd := exec.CommandContext(ctx, c.Binary, argv...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// children left behind holding the pipes must not keep the request hanging
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	result := execResult{
+		Command:   c.Name,
+		Args:      argv,
+		Stdout:    stdout.buf.String(),
+		Stderr:    stderr.buf.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		Duration:  time.Since(start),
+	}
+	var status int
+	result.ExitCode, result.TimedOut, result.Error, status = c.outcome(ctx, cmd, err)
+	return result, status
+}
+
+// outcome tells how the finished command went, err is what Run or Wait returned
+func (c *command) outcome(ctx context.Context, cmd *exec.Cmd, err error) (exitCode int, timedOut bool, message string, status int) {
+	exitCode = -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return exitCode, true, fmt.Sprintf("killed after %s", c.timeout()), http.StatusGatewayTimeout
+	case err == nil:
+		return exitCode, false, "", http.StatusOK
+	case errors.As(err, &exitErr) && exitCode > 0:
+		return exitCode, false, fmt.Sprintf("exit status %d", exitCode), http.StatusBadGateway
+	default:
+		return exitCode, false, err.Error(), http.StatusInternalServerError
+	}
+}
+
+// execHandler runs /exec/:command with query parameters as arguments and returns its outcome as json.
+// commands exiting with non-zero code get 502, killed by deadline 504.
+func execHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	name := params.ByName("command")
+	c, argv, status, err := prepare(r, name, false)
+	if err != nil {
+		audit.Printf("remote=%s command=%q query=%q status=%d rejected: %s", r.RemoteAddr, name, r.URL.RawQuery, status, err)
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	release, err := acquire()
+	if err != nil {
+		audit.Printf("remote=%s command=%q args=%q status=%d rejected: %s", r.RemoteAddr, name, argv, http.StatusTooManyRequests, err)
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{err.Error()})
+		return
+	}
+	defer release()
+
+	result, status := c.run(r.Context(), argv)
+	audit.Printf("remote=%s command=%q args=%q status=%d exit=%d duration=%s truncated=%t", r.RemoteAddr, name, argv, status, result.ExitCode, result.Duration, result.Truncated)
+	writeJSON(w, status, result)
+}
+
+// commandsHandler lists allowlisted commands with their argument schema
+func commandsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	type argInfo struct {
+		argSpec
+		Pattern string `json:"pattern,omitempty"`
+	}
+	type commandInfo struct {
+		*command
+		Args []argInfo `json:"args,omitempty"`
+	}
+	list := make([]commandInfo, 0, len(commands))
+	for _, c := range commands {
+		info := commandInfo{command: c}
+		for _, spec := range c.Args {
+			arg := argInfo{argSpec: spec}
+			if spec.Pattern != nil {
+				arg.Pattern = spec.Pattern.String()
+			}
+			info.Args = append(info.Args, arg)
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, list)
+}
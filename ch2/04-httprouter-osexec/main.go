@@ -1,59 +1,78 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"flag"
 	"log"
 	"net/http"
-	"os/exec"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
+	procRoot := flag.String("proc", "/proc", "proc filesystem root, a directory with fake files works for testing")
+	flag.Parse()
+	if err := setupCommands(); err != nil {
+		log.Fatalln(err)
+	}
+
+	sys := sysInfo{root: *procRoot, statfs: statfs}
 	router := httprouter.New()
-	router.GET("/uptime", uptime)
-	router.GET("/diskuse/:name", diskuse)
+	router.GET("/uptime", sys.loadHandler)
+	router.GET("/memory", sys.memoryHandler)
+	router.GET("/diskuse", sys.disksHandler)
+	router.GET("/diskuse/*mount", sys.diskHandler)
+	router.GET("/cpu", sys.cpuHandler)
+	router.GET("/net", sys.netHandler)
+	router.GET("/exec", commandsHandler)
+	router.GET("/exec/:command", execHandler)
+	router.GET("/stream/:command", streamHandler)
 	log.Fatalln(http.ListenAndServe("localhost:8080", router))
 }
 
-func execCmd(cmd string, args ...string) string {
-	out, err := exec.Command(cmd, args...).Output()
-	if err != nil {
-		return fmt.Sprintln(err)
-	}
-	return string(out)
-}
+/*
+# metrics are read from /proc and statfs, -proc points to another proc root like testdata/proc
+~ $ curl -w'\n' localhost:8080/uptime
+{"load1":2.12,"load5":1.92,"load15":1.86,"running":2,"processes":412,"uptime":17587.21,"idle":63010.5}
 
-func uptime(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	response := execCmd("uptime")
-	io.WriteString(w, response)
-	return
-}
+~ $ curl -w'\n' localhost:8080/memory
+{"total":8264966144,"free":1123467264,"available":5021413376,"used":3243552768,"buffers":201326592,"cached":3489660928,"swap_total":2147479552,"swap_free":2147479552}
 
-func diskuse(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	response := execCmd("df", "-h", params.ByName("name"))
-	io.WriteString(w, response)
-	return
-}
+# all real filesystems, or a single mount point, /diskuse/ is the root filesystem
+~ $ curl -w'\n' localhost:8080/diskuse/home
+{"device":"/dev/sda2","mount":"/home","fstype":"ext4","total":490577010688,"free":369229492224,"available":344260313088,"used":121347518464,"inodes":30531584,"inodes_free":29717123,"used_percent":26.06}
+~ $ curl -w'\n' localhost:8080/diskuse/etc
+{"error":"not a mount point"}
 
-/*
-~ $ curl -i -w'\n' localhost:8080/uptime
-HTTP/1.1 200 OK
-Date: Thu, 18 Feb 2021 12:33:03 GMT
-Content-Length: 64
-Content-Type: text/plain; charset=utf-8
-
- 14:33:03  up   4:53,  3 users,  load average: 2.12, 1.92, 1.86
-
-~ $ curl -i -w'\n' localhost:8080/diskuse/-l
-HTTP/1.1 200 OK
-Date: Thu, 18 Feb 2021 12:33:05 GMT
-Content-Length: 199
-Content-Type: text/plain; charset=utf-8
-
-Filesystem      Size  Used Avail Use% Mounted on
-/dev/disk1s5    466G   11G  333G   4% /
-/dev/disk1s4    466G  2.1G  333G   1% /private/var/vm
-/dev/disk1s3    466G  505M  333G   1% /Volumes/Recovery
+# cpu times in seconds, the first entry sums all cpus
+~ $ curl -w'\n' localhost:8080/cpu
+[{"cpu":"cpu","user":279.69,"nice":0,"system":42.56,"idle":1574.73,"iowait":2.33,"irq":0,"softirq":0.05,"steal":9.1},{"cpu":"cpu0","user":279.69,"nice":0,"system":42.56,"idle":1574.73,"iowait":2.33,"irq":0,"softirq":0.05,"steal":9.1}]
+
+~ $ curl -w'\n' localhost:8080/net
+[{"interface":"lo","rx_bytes":33852184,"rx_packets":6620,"rx_errors":0,"rx_dropped":0,"tx_bytes":33852184,"tx_packets":6620,"tx_errors":0,"tx_dropped":0}]
+
+# allowlisted commands run with validated arguments, deadline and output limits, /exec lists them
+~ $ curl -w'\n' 'localhost:8080/exec/df?mount=/home'
+{"command":"df","args":["-h","--","/home"],"exit_code":0,"stdout":"Filesystem      Size  Used Avail Use% Mounted on\n/dev/sda2       457G  114G  321G  27% /home\n","stderr":"","duration":3524117}
+~ $ curl -w'\n' 'localhost:8080/exec/df?mount=-l'
+{"error":"mount value \"-l\" is not allowed"}
+~ $ curl -w'\n' 'localhost:8080/exec/du?path=/nonexistent'
+{"command":"du","args":["-s","-h","--","/nonexistent"],"exit_code":1,"stdout":"","stderr":"du: cannot access '/nonexistent': No such file or directory\n","duration":2012385,"error":"exit status 1"}
+
+# long running commands stream their output line by line as server sent events, or websocket messages
+# when the request asks for an upgrade. closing the connection kills the process.
+~ $ curl -N 'localhost:8080/stream/follow?file=/var/log/syslog&lines=2'
+event: stdout
+data: {"line":"Feb 18 12:41:01 box CRON[20516]: (root) CMD (command -v debian-sa1 > /dev/null && debian-sa1 1 1)"}
+
+event: stdout
+data: {"line":"Feb 18 12:45:01 box CRON[20533]: (root) CMD (command -v debian-sa1 > /dev/null && debian-sa1 1 1)"}
+
+^C
+~ $ websocat 'ws://localhost:8080/stream/du?path=/usr&unit=-m'
+{"event":"stdout","line":"2615\t/usr"}
+{"event":"end","end":{"exit_code":0,"duration":647419140}}
+
+# every invocation goes to the audit log, -audit-log writes it to a file
+audit: 2021/02/18 12:40:02 remote=127.0.0.1:53412 command="df" args=["-h" "--" "/home"] status=200 exit=0 duration=3.524117ms truncated=false
+audit: 2021/02/18 12:40:09 remote=127.0.0.1:53414 command="df" query="mount=-l" status=400 rejected: mount value "-l" is not allowed
 */
@@ -0,0 +1,19 @@
+package main
+
+import "syscall"
+
+// statfs returns sizes of the filesystem mounted at mount
+func statfs(mount string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mount, &st); err != nil {
+		return diskUsage{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return diskUsage{
+		Total:      st.Blocks * bsize,
+		Free:       st.Bfree * bsize,
+		Available:  st.Bavail * bsize,
+		Inodes:     st.Files,
+		InodesFree: st.Ffree,
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// statfs is implemented for linux only, like the rest of /proc based metrics
+func statfs(mount string) (diskUsage, error) {
+	return diskUsage{}, errors.New("disk usage is supported on linux only")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// streaming limits (set with command line flags)
+var (
+	maxStreamOutput int64
+	maxLine         int
+)
+
+func init() {
+	flag.Int64Var(&maxStreamOutput, "max-stream-output", 64<<20, "bytes streamed to a client before the command is killed")
+	flag.IntVar(&maxLine, "max-line", 64<<10, "longer lines are streamed in pieces of this size")
+}
+
+// streamEvent is a single line of output or the final event carrying the exit status
+type streamEvent struct {
+	Event string     `json:"event"` // stdout, stderr or end
+	Line  string     `json:"line,omitempty"`
+	End   *streamEnd `json:"end,omitempty"`
+}
+
+// streamEnd responce struct sent when the command is over
+type streamEnd struct {
+	ExitCode  int           `json:"exit_code"` // -1 if the process didn't exit on its own
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// errOutputLimit and errClientGone tell why a streaming command was killed
+var (
+	errOutputLimit = errors.New("killed after streaming max-stream-output bytes")
+	errClientGone  = errors.New("killed, client went away")
+)
+
+// stream runs the command passing its output line by line to emit, the process is killed
+// once emit fails or ctx is done, which is how client disconnects stop it
+func (c *command) stream(ctx context.Context, argv []string, emit func(streamEvent) error) streamEnd {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Binary, argv...)
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return streamEnd{ExitCode: -1, Error: err.Error()}
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return streamEnd{ExitCode: -1, Error: err.Error()}
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return streamEnd{ExitCode: -1, Error: err.Error()}
+	}
+
+	lines := make(chan streamEvent)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go readLines("stdout", stdout, lines, &wg)
+	go readLines("stderr", stderr, lines, &wg)
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	// pipes are drained to the end even after the kill so Wait can be called safely
+	var sent int64
+	var killed error
+	for ev := range lines {
+		if killed != nil {
+			continue
+		}
+		if sent += int64(len(ev.Line)); sent > maxStreamOutput {
+			killed = errOutputLimit
+			cancel()
+			continue
+		}
+		if err := emit(ev); err != nil {
+			killed = errClientGone
+			cancel()
+		}
+	}
+	err = cmd.Wait()
+
+	end := streamEnd{Duration: time.Since(start), Truncated: killed == errOutputLimit}
+	end.ExitCode, end.TimedOut, end.Error, _ = c.outcome(ctx, cmd, err)
+	switch {
+	case killed != nil:
+		end.Error = killed.Error()
+	case ctx.Err() != nil && !end.TimedOut:
+		end.Error = errClientGone.Error()
+	}
+	return end
+}
+
+// readLines sends lines of r to lines, lines longer than maxLine are split
+func readLines(name string, r io.Reader, lines chan<- streamEvent, wg *sync.WaitGroup) {
+	defer wg.Done()
+	br := bufio.NewReaderSize(r, maxLine)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			lines <- streamEvent{Event: name, Line: strings.TrimRight(string(line), "\r\n")}
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// upgrader accepts websocket connections from pages of the same origin only
+var upgrader = websocket.Upgrader{}
+
+// streamHandler runs /stream/:command and streams its output as server sent events,
+// or as websocket json messages when the request asks for a websocket upgrade.
+// the last event is "end" with the exit status.
+func streamHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	name := params.ByName("command")
+	c, argv, status, err := prepare(r, name, true)
+	if err != nil {
+		audit.Printf("remote=%s stream command=%q query=%q status=%d rejected: %s", r.RemoteAddr, name, r.URL.RawQuery, status, err)
+		writeJSON(w, status, errorResponse{err.Error()})
+		return
+	}
+	release, err := acquire()
+	if err != nil {
+		audit.Printf("remote=%s stream command=%q args=%q status=%d rejected: %s", r.RemoteAddr, name, argv, http.StatusTooManyRequests, err)
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{err.Error()})
+		return
+	}
+	defer release()
+
+	var end streamEnd
+	var transport string
+	if websocket.IsWebSocketUpgrade(r) {
+		transport = "websocket"
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // upgrader has already replied
+		}
+		end = streamWebSocket(r.Context(), conn, c, argv)
+	} else {
+		transport = "sse"
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{"streaming unsupported"})
+			return
+		}
+		end = streamSSE(r.Context(), w, flusher, c, argv)
+	}
+	audit.Printf("remote=%s stream=%s command=%q args=%q exit=%d duration=%s error=%q", r.RemoteAddr, transport, name, argv, end.ExitCode, end.Duration, end.Error)
+}
+
+// streamSSE writes output lines as stdout and stderr events with {"line": ...} data, then the end event
+func streamSSE(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, c *command, argv []string) streamEnd {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would hold events back otherwise
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	end := c.stream(ctx, argv, func(ev streamEvent) error {
+		return send(ev.Event, struct {
+			Line string `json:"line"`
+		}{ev.Line})
+	})
+	send("end", end)
+	return end
+}
+
+// streamWebSocket sends every event as a json text message and closes the connection after the end event.
+// the read loop notices the client closing the socket and stops the command.
+func streamWebSocket(ctx context.Context, conn *websocket.Conn, c *command, argv []string) streamEnd {
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	send := func(ev streamEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(ev)
+	}
+	end := c.stream(ctx, argv, send)
+	if send(streamEvent{Event: "end", End: &end}) == nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+	return end
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// userHZ is the unit of cpu times in /proc/stat, it is 100 on every mainstream linux build
+const userHZ = 100
+
+// pseudoFS are filesystems without disk usage worth reporting
+var pseudoFS = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true, "configfs": true,
+	"debugfs": true, "devpts": true, "fusectl": true, "hugetlbfs": true, "mqueue": true, "nsfs": true,
+	"proc": true, "pstore": true, "rpc_pipefs": true, "securityfs": true, "sysfs": true, "tracefs": true,
+}
+
+// sysInfo reads system metrics from proc filesystem mounted at root, a fake root serves canned files
+type sysInfo struct {
+	root   string
+	statfs func(mount string) (diskUsage, error)
+}
+
+// errorResponse is returned on bad requests and failures
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON encodes v as json response with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// load responce struct
+type load struct {
+	Load1     float64 `json:"load1"`
+	Load5     float64 `json:"load5"`
+	Load15    float64 `json:"load15"`
+	Running   int     `json:"running"`
+	Processes int     `json:"processes"`
+	Uptime    float64 `json:"uptime"` // seconds
+	Idle      float64 `json:"idle"`   // seconds summed over all cpus
+}
+
+// memory responce struct, all values are bytes
+type memory struct {
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	Available uint64 `json:"available"`
+	Used      uint64 `json:"used"`
+	Buffers   uint64 `json:"buffers"`
+	Cached    uint64 `json:"cached"`
+	SwapTotal uint64 `json:"swap_total"`
+	SwapFree  uint64 `json:"swap_free"`
+}
+
+// diskUsage responce struct of a single mount, sizes are bytes
+type diskUsage struct {
+	Device      string  `json:"device"`
+	Mount       string  `json:"mount"`
+	FSType      string  `json:"fstype"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Available   uint64  `json:"available"` // to unprivileged users
+	Used        uint64  `json:"used"`
+	Inodes      uint64  `json:"inodes"`
+	InodesFree  uint64  `json:"inodes_free"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// cpuTimes responce struct, times are seconds since boot
+type cpuTimes struct {
+	CPU     string  `json:"cpu"` // "cpu" is the sum of all cpus
+	User    float64 `json:"user"`
+	Nice    float64 `json:"nice"`
+	System  float64 `json:"system"`
+	Idle    float64 `json:"idle"`
+	IOWait  float64 `json:"iowait"`
+	IRQ     float64 `json:"irq"`
+	SoftIRQ float64 `json:"softirq"`
+	Steal   float64 `json:"steal"`
+}
+
+// netCounters responce struct of a single interface
+type netCounters struct {
+	Interface string `json:"interface"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// mountEntry is a single line of mounts file
+type mountEntry struct {
+	device string
+	mount  string
+	fstype string
+}
+
+// file returns name of a file relative to proc root
+func (s sysInfo) file(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// fields reads file and splits its lines into fields
+func (s sysInfo) fields(name string) ([][]string, error) {
+	f, err := os.Open(s.file(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20) // /proc/stat intr line gets long
+	for scanner.Scan() {
+		lines = append(lines, strings.Fields(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
+
+// floats parses all values as floats
+func floats(values []string) ([]float64, error) {
+	out := make([]float64, len(values))
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+// load reads loadavg and uptime
+func (s sysInfo) load() (load, error) {
+	var l load
+	lines, err := s.fields("loadavg")
+	if err != nil {
+		return l, err
+	}
+	if len(lines) == 0 || len(lines[0]) < 4 {
+		return l, fmt.Errorf("loadavg: unexpected format")
+	}
+	avg, err := floats(lines[0][:3])
+	if err != nil {
+		return l, fmt.Errorf("loadavg: %s", err)
+	}
+	l.Load1, l.Load5, l.Load15 = avg[0], avg[1], avg[2]
+	if _, err := fmt.Sscanf(lines[0][3], "%d/%d", &l.Running, &l.Processes); err != nil {
+		return l, fmt.Errorf("loadavg: %s", err)
+	}
+
+	lines, err = s.fields("uptime")
+	if err != nil {
+		return l, err
+	}
+	if len(lines) == 0 || len(lines[0]) < 2 {
+		return l, fmt.Errorf("uptime: unexpected format")
+	}
+	up, err := floats(lines[0][:2])
+	if err != nil {
+		return l, fmt.Errorf("uptime: %s", err)
+	}
+	l.Uptime, l.Idle = up[0], up[1]
+	return l, nil
+}
+
+// memory reads meminfo, used memory is what is neither free nor reclaimable
+func (s sysInfo) memory() (memory, error) {
+	var m memory
+	lines, err := s.fields("meminfo")
+	if err != nil {
+		return m, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range lines {
+		if len(line) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(line[1], 10, 64)
+		if err != nil {
+			return m, fmt.Errorf("meminfo: %s", err)
+		}
+		if len(line) > 2 && line[2] == "kB" {
+			v *= 1024
+		}
+		values[strings.TrimSuffix(line[0], ":")] = v
+	}
+	if _, ok := values["MemTotal"]; !ok {
+		return m, fmt.Errorf("meminfo: no MemTotal")
+	}
+	m = memory{
+		Total:     values["MemTotal"],
+		Free:      values["MemFree"],
+		Available: values["MemAvailable"],
+		Buffers:   values["Buffers"],
+		Cached:    values["Cached"],
+		SwapTotal: values["SwapTotal"],
+		SwapFree:  values["SwapFree"],
+	}
+	if m.Available <= m.Total {
+		m.Used = m.Total - m.Available
+	}
+	return m, nil
+}
+
+// mounts reads mount table of the process
+func (s sysInfo) mounts() ([]mountEntry, error) {
+	lines, err := s.fields("self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mountEntry
+	for _, line := range lines {
+		if len(line) < 3 {
+			continue
+		}
+		mounts = append(mounts, mountEntry{unescapeMount(line[0]), unescapeMount(line[1]), line[2]})
+	}
+	return mounts, nil
+}
+
+// unescapeMount decodes octal escapes the kernel uses for spaces, tabs and backslashes in mounts file
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// disks returns usage of mounts with real filesystems, the last mount wins when a path is mounted over
+func (s sysInfo) disks() ([]diskUsage, error) {
+	mounts, err := s.mounts()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	var disks []diskUsage
+	for _, m := range mounts {
+		if pseudoFS[m.fstype] {
+			continue
+		}
+		usage, err := s.usage(m)
+		if err != nil {
+			// mounts of other namespaces or gone network shares shouldn't break the whole list
+			continue
+		}
+		if i, ok := index[m.mount]; ok {
+			disks[i] = usage
+			continue
+		}
+		index[m.mount] = len(disks)
+		disks = append(disks, usage)
+	}
+	return disks, nil
+}
+
+// disk returns usage of a single mount, the path must be a mount point listed in the mount table
+func (s sysInfo) disk(mount string) (diskUsage, error) {
+	if !strings.HasPrefix(mount, "/") || path.Clean(mount) != mount {
+		return diskUsage{}, errInvalidMount
+	}
+	mounts, err := s.mounts()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if mounts[i].mount == mount {
+			return s.usage(mounts[i])
+		}
+	}
+	return diskUsage{}, errUnknownMount
+}
+
+// mount lookup errors
+var (
+	errInvalidMount = fmt.Errorf("mount must be an absolute clean path")
+	errUnknownMount = fmt.Errorf("not a mount point")
+)
+
+// usage calls statfs on the mount and fills in the mount table details
+func (s sysInfo) usage(m mountEntry) (diskUsage, error) {
+	usage, err := s.statfs(m.mount)
+	if err != nil {
+		return usage, err
+	}
+	usage.Device, usage.Mount, usage.FSType = m.device, m.mount, m.fstype
+	if usage.Total > 0 {
+		usage.Used = usage.Total - usage.Free
+		// like df, percent of the space available to users
+		if capacity := usage.Used + usage.Available; capacity > 0 {
+			usage.UsedPercent = float64(usage.Used) * 100 / float64(capacity)
+		}
+	}
+	return usage, nil
+}
+
+// cpus reads cpu times of all cpus followed by each cpu from stat
+func (s sysInfo) cpus() ([]cpuTimes, error) {
+	lines, err := s.fields("stat")
+	if err != nil {
+		return nil, err
+	}
+	var cpus []cpuTimes
+	for _, line := range lines {
+		if len(line) < 9 || !strings.HasPrefix(line[0], "cpu") {
+			continue
+		}
+		ticks, err := floats(line[1:9])
+		if err != nil {
+			return nil, fmt.Errorf("stat: %s", err)
+		}
+		for i := range ticks {
+			ticks[i] /= userHZ
+		}
+		cpus = append(cpus, cpuTimes{line[0], ticks[0], ticks[1], ticks[2], ticks[3], ticks[4], ticks[5], ticks[6], ticks[7]})
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("stat: no cpu lines")
+	}
+	return cpus, nil
+}
+
+// interfaces reads network interface counters from net/dev
+func (s sysInfo) interfaces() ([]netCounters, error) {
+	lines, err := s.fields("net/dev")
+	if err != nil {
+		return nil, err
+	}
+	var counters []netCounters
+	for _, line := range lines {
+		// "eth0:" and "eth0:123" are both possible when counters get wide
+		joined := strings.Join(line, " ")
+		name, rest, ok := strings.Cut(joined, ":")
+		if !ok {
+			continue
+		}
+		values := strings.Fields(rest)
+		if len(values) < 16 {
+			continue
+		}
+		n := make([]uint64, 16)
+		for i := range n {
+			if n[i], err = strconv.ParseUint(values[i], 10, 64); err != nil {
+				return nil, fmt.Errorf("net/dev: %s", err)
+			}
+		}
+		counters = append(counters, netCounters{strings.TrimSpace(name), n[0], n[1], n[2], n[3], n[8], n[9], n[10], n[11]})
+	}
+	return counters, nil
+}
+
+// respond writes v or a 500 error
+func respond(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s sysInfo) loadHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v, err := s.load()
+	respond(w, v, err)
+}
+
+func (s sysInfo) memoryHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v, err := s.memory()
+	respond(w, v, err)
+}
+
+func (s sysInfo) disksHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v, err := s.disks()
+	respond(w, v, err)
+}
+
+// diskHandler returns usage of /disk/*mount, like /disk/ for the root filesystem
+func (s sysInfo) diskHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	v, err := s.disk(params.ByName("mount"))
+	switch err {
+	case errInvalidMount:
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+	case errUnknownMount:
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+	default:
+		respond(w, v, err)
+	}
+}
+
+func (s sysInfo) cpuHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v, err := s.cpus()
+	respond(w, v, err)
+}
+
+func (s sysInfo) netHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	v, err := s.interfaces()
+	respond(w, v, err)
+}
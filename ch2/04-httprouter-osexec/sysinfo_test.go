@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// fakeStatfs reports the same sizes for every mount of testdata/proc/self/mounts
+func fakeStatfs(mount string) (diskUsage, error) {
+	switch mount {
+	case "/", "/home", "/mnt/backup disk":
+		return diskUsage{Total: 1000, Free: 400, Available: 300, Inodes: 100, InodesFree: 60}, nil
+	}
+	return diskUsage{}, fmt.Errorf("statfs %s: no such file or directory", mount)
+}
+
+// sysRouter routes metrics of sys like main does
+func sysRouter(sys sysInfo) http.Handler {
+	router := httprouter.New()
+	router.GET("/uptime", sys.loadHandler)
+	router.GET("/memory", sys.memoryHandler)
+	router.GET("/diskuse", sys.disksHandler)
+	router.GET("/diskuse/*mount", sys.diskHandler)
+	router.GET("/cpu", sys.cpuHandler)
+	router.GET("/net", sys.netHandler)
+	return router
+}
+
+func TestSysInfoHandlers(t *testing.T) {
+	fixture := sysRouter(sysInfo{root: "testdata/proc", statfs: fakeStatfs})
+	missing := sysRouter(sysInfo{root: "testdata/missing", statfs: fakeStatfs})
+
+	disk := func(device, mount string) string {
+		return fmt.Sprintf(`{"device":%q,"mount":%q,"fstype":"ext4","total":1000,"free":400,"available":300,"used":600,"inodes":100,"inodes_free":60,"used_percent":66.66666666666667}`, device, mount)
+	}
+	tests := []struct {
+		name   string
+		router http.Handler
+		path   string
+		status int
+		body   string
+	}{
+		{"load", fixture, "/uptime", http.StatusOK,
+			`{"load1":2.12,"load5":1.92,"load15":1.86,"running":2,"processes":412,"uptime":17587.21,"idle":63010.5}`},
+		{"memory", fixture, "/memory", http.StatusOK,
+			`{"total":8264966144,"free":1123467264,"available":5021413376,"used":3243552768,"buffers":201326592,"cached":3489660928,"swap_total":2147479552,"swap_free":2147479552}`},
+		{"all disks skip pseudo filesystems", fixture, "/diskuse", http.StatusOK,
+			"[" + disk("/dev/sda1", "/") + "," + disk("/dev/sda2", "/home") + "," + disk("/dev/sdb1", "/mnt/backup disk") + "]"},
+		{"root filesystem", fixture, "/diskuse/", http.StatusOK, disk("/dev/sda1", "/")},
+		{"mount point", fixture, "/diskuse/home", http.StatusOK, disk("/dev/sda2", "/home")},
+		{"escaped mount point", fixture, "/diskuse/mnt/backup%20disk", http.StatusOK, disk("/dev/sdb1", "/mnt/backup disk")},
+		{"not a mount point", fixture, "/diskuse/home/user", http.StatusNotFound, `{"error":"not a mount point"}`},
+		{"pseudo filesystem is no disk", fixture, "/diskuse/etc", http.StatusNotFound, `{"error":"not a mount point"}`},
+		{"dot dot", fixture, "/diskuse/home/..", http.StatusBadRequest, `{"error":"mount must be an absolute clean path"}`},
+		{"trailing slash", fixture, "/diskuse/home/", http.StatusBadRequest, `{"error":"mount must be an absolute clean path"}`},
+		{"double slash", fixture, "/diskuse//home", http.StatusBadRequest, `{"error":"mount must be an absolute clean path"}`},
+		{"cpu", fixture, "/cpu", http.StatusOK,
+			`[{"cpu":"cpu","user":279.69,"nice":0,"system":42.56,"idle":1574.73,"iowait":2.33,"irq":0,"softirq":0.05,"steal":9.1},` +
+				`{"cpu":"cpu0","user":140.01,"nice":0,"system":21,"idle":787,"iowait":1.2,"irq":0,"softirq":0.03,"steal":4.55},` +
+				`{"cpu":"cpu1","user":139.68,"nice":0,"system":21.56,"idle":787.73,"iowait":1.13,"irq":0,"softirq":0.02,"steal":4.55}]`},
+		{"net", fixture, "/net", http.StatusOK,
+			`[{"interface":"lo","rx_bytes":35163448,"rx_packets":6760,"rx_errors":0,"rx_dropped":0,"tx_bytes":35163448,"tx_packets":6760,"tx_errors":0,"tx_dropped":0},` +
+				`{"interface":"eth0","rx_bytes":1284512931,"rx_packets":1032211,"rx_errors":0,"rx_dropped":12,"tx_bytes":98231442,"tx_packets":611203,"tx_errors":0,"tx_dropped":0}]`},
+		{"missing proc files", missing, "/uptime", http.StatusInternalServerError, ""},
+		{"missing mounts", missing, "/diskuse/", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if tt.body == "" {
+				return
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("bad json %q: %s", rec.Body, err)
+			}
+			if err := json.Unmarshal([]byte(tt.body), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("body = %s\nwant   %s", rec.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestUnescapeMount(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/home", "/home"},
+		{`/mnt/backup\040disk`, "/mnt/backup disk"},
+		{`/mnt/tab\011and\134slash`, "/mnt/tab\tand\\slash"},
+		{`/mnt/short\04`, `/mnt/short\04`},
+		{`/mnt/not\999octal`, `/mnt/not\999octal`},
+	}
+	for _, tt := range tests {
+		if got := unescapeMount(tt.in); got != tt.want {
+			t.Errorf("unescapeMount(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+module middleware-chaining
+
+go 1.16
+
+require (
+	github.com/justinas/alice v1.2.0
+	goweb/pkg v0.0.0
+)
+
+replace goweb/pkg => ../../pkg
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -8,6 +9,8 @@ import (
 	"time"
 
 	"github.com/justinas/alice"
+
+	"goweb/pkg/middleware"
 )
 
 // city struct used for request unmarshaling (json body)
@@ -48,7 +51,9 @@ func handle(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		var c city
 		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			panic(err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - Bad Request. " + err.Error()))
+			return
 		}
 		defer r.Body.Close()
 
@@ -62,7 +67,24 @@ func handle(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	http.Handle("/", alice.New(filterContentType, setServerTimeCookie).Then(http.HandlerFunc(handle)))
+	// requests coming through a proxy on this host are logged with the client address
+	realIP, err := middleware.RealIP("127.0.0.1", "::1")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// production middleware goes first so errors of the demo ones are handled too
+	common := alice.New(
+		middleware.RequestID,
+		middleware.Recover(nil),
+		realIP,
+		middleware.MaxBodySize(1<<20),
+		middleware.Timeout(5*time.Second),
+		middleware.Gzip(gzip.DefaultCompression),
+	)
+	http.Handle("/", common.Append(filterContentType, setServerTimeCookie).Then(http.HandlerFunc(handle)))
+	http.Handle("/panic", common.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}))
 	// http.Handle("/", filterContentType(setServerTimeCookie(http.HandlerFunc(handle))))
 	http.ListenAndServe("localhost:8080", nil)
 }
@@ -78,18 +100,59 @@ $ go run main.go
 
 $ curl -i -w'\n' localhost:8080/
 HTTP/1.1 415 Unsupported Media Type
+Vary: Accept-Encoding
+X-Request-Id: 9c1b0f6e2d4a4b7f8e3a5c6d7e8f9a0b
 Date: Fri, 19 Feb 2021 14:28:13 GMT
 Content-Length: 58
 Content-Type: text/plain; charset=utf-8
 
 415 - Unsupported Media Type. Please send application/json
 
-$ curl -i -w'\n' localhost:8080/ -d '{"name": "Korosten", "area": 42.31}' -H "Content-Type: application/json"
+$ curl -i -w'\n' localhost:8080/ -d '{"name": "Korosten", "area": 42.31}' -H "Content-Type: application/json" -H 'X-Request-ID: abc-123'
 HTTP/1.1 200 OK
 Set-Cookie: ServerTimeUTC=1613744922
+Vary: Accept-Encoding
+X-Request-Id: abc-123
 Date: Fri, 19 Feb 2021 14:28:42 GMT
 Content-Length: 13
 Content-Type: text/plain; charset=utf-8
 
 201 - Created
+
+$ curl -i -w'\n' localhost:8080/ -d '{"name": ' -H "Content-Type: application/json"
+HTTP/1.1 400 Bad Request
+Set-Cookie: ServerTimeUTC=1613744930
+Vary: Accept-Encoding
+X-Request-Id: 05f17aedbfa53c89f011958f10f0dbf6
+Date: Fri, 19 Feb 2021 14:28:50 GMT
+Content-Length: 33
+Content-Type: text/plain; charset=utf-8
+
+400 - Bad Request. unexpected EOF
+
+// panics are logged with the stack and answered with json
+$ curl -i -w'\n' localhost:8080/panic
+HTTP/1.1 500 Internal Server Error
+Content-Type: application/json
+X-Content-Type-Options: nosniff
+X-Request-Id: b70bb2035ce18fb865af9a2adbfccad1
+Date: Fri, 19 Feb 2021 14:29:01 GMT
+Content-Length: 82
+
+{"error":"internal server error","request_id":"b70bb2035ce18fb865af9a2adbfccad1"}
+
+2021/02/19 16:29:01 panic: GET /panic request_id=b70bb2035ce18fb865af9a2adbfccad1: something went wrong
+goroutine 14 [running]:
+...
+
+$ head -c 2000000 /dev/zero | curl -i -w'\n' localhost:8080/ --data-binary @- -H "Content-Type: application/json"
+HTTP/1.1 413 Request Entity Too Large
+Content-Type: application/json
+X-Content-Type-Options: nosniff
+X-Request-Id: a4f054cdd75e1c26bf8e070e045ed2b8
+Date: Fri, 19 Feb 2021 14:29:15 GMT
+Content-Length: 92
+Connection: close
+
+{"error":"request body over 1048576 bytes","request_id":"a4f054cdd75e1c26bf8e070e045ed2b8"}
 */
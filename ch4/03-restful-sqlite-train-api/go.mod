@@ -0,0 +1,12 @@
+module train-api
+
+go 1.16
+
+require (
+	github.com/emicklei/go-restful v2.15.0+incompatible
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
+	goweb/pkg v0.0.0
+)
+
+replace goweb/pkg => ../../pkg
@@ -3,12 +3,15 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/emicklei/go-restful"
 	_ "github.com/mattn/go-sqlite3"
+
+	"goweb/pkg/server"
 )
 
 const (
@@ -122,6 +125,7 @@ func (t train) createTrain(r *restful.Request, w *restful.Response) {
 	}
 	ID, _ := result.LastInsertId()
 	b.ID = int(ID)
+	log.Printf("train %d created by %s", b.ID, caller(r))
 	w.WriteHeaderAndEntity(http.StatusCreated, b)
 }
 
@@ -149,11 +153,45 @@ func (t train) removeTrain(r *restful.Request, w *restful.Response) {
 		w.WriteErrorString(http.StatusInternalServerError, err.Error())
 		return
 	}
+	log.Printf("train %s removed by %s", id, caller(r))
 	w.WriteHeader(http.StatusOK)
 }
 
+// caller names the client of the request for the log, common name of its verified certificate under mTLS
+func caller(r *restful.Request) string {
+	if id, ok := server.ClientIdentityFrom(r.Request.Context()); ok {
+		return id.CommonName
+	}
+	return "anonymous client " + r.Request.RemoteAddr
+}
+
+// registerWhoami adds route showing the verified client certificate
+func registerWhoami(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/v1/whoami").Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").To(whoami))
+	container.Add(ws)
+}
+
+// GET https://localhost:8080/v1/whoami
+func whoami(r *restful.Request, w *restful.Response) {
+	id, ok := server.ClientIdentityFrom(r.Request.Context())
+	if !ok {
+		w.AddHeader("Content-Type", "text/plain")
+		w.WriteErrorString(http.StatusUnauthorized, "No verified client certificate, use https with -client-auth optional or require.")
+		return
+	}
+	w.WriteEntity(id)
+}
+
 // entrypoint
 func main() {
+	cfg := server.Defaults()
+	if err := cfg.RegisterFlags(flag.CommandLine, "TRAIN_API_"); err != nil {
+		log.Fatal(err)
+	}
+	flag.Parse()
+
 	if err := InitDB(); err != nil {
 		log.Fatal(err)
 	}
@@ -163,9 +201,13 @@ func main() {
 	container.Router(restful.CurlyRouter{})
 	t := train{}
 	t.Register(container)
+	registerWhoami(container)
 
-	log.Println("start listening on localhost:8080")
-	log.Fatal(http.ListenAndServe("localhost:8080", container))
+	srv := server.New(cfg, container)
+	srv.OnShutdown("database", server.Close(db))
+	if err := srv.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 /*
@@ -201,4 +243,43 @@ HTTP/1.1 200 OK
 Date: Mon, 22 Feb 2021 07:57:15 GMT
 Content-Length: 0
 
+
+// HTTPS for development: a local CA and certificates are generated on first start and cached
+$ go run . -dev-tls -client-auth require
+2021/02/22 08:02:11 dev-tls: created CA /home/user/.cache/goweb/dev-tls/ca.pem, trust it with curl --cacert or by adding it to the system store
+2021/02/22 08:02:11 dev-tls: issued /home/user/.cache/goweb/dev-tls/server.pem for localhost
+2021/02/22 08:02:11 dev-tls: issued /home/user/.cache/goweb/dev-tls/client.pem for dev-client
+2021/02/22 08:02:11 dev-tls: client certificate for testing is /home/user/.cache/goweb/dev-tls/client.pem with key /home/user/.cache/goweb/dev-tls/client-key.pem
+2021/02/22 08:02:11 listening on tcp 127.0.0.1:8080 with tls
+
+$ cd ~/.cache/goweb/dev-tls && curl -i -w '\n' --cacert ca.pem --cert client.pem --key client-key.pem https://localhost:8080/v1/trains/1
+HTTP/2 200
+content-type: application/json
+content-length: 52
+date: Mon, 22 Feb 2021 08:02:40 GMT
+
+{
+ "ID": 1,
+ "driver": "Veronica",
+ "status": true
+}
+
+// handlers see the verified client certificate, changes are logged with its common name
+$ curl -w '\n' --cacert ca.pem --cert client.pem --key client-key.pem https://localhost:8080/v1/whoami
+{
+ "common_name": "dev-client",
+ "subject": "CN=dev-client",
+ "issuer": "CN=goweb dev CA,O=goweb development CA",
+ "serial_number": "4532f28d6a39d05290ae541f2ebc9fb1",
+ "not_after": "2022-02-22T08:02:11Z"
+}
+$ curl -X DELETE --cacert ca.pem --cert client.pem --key client-key.pem https://localhost:8080/v1/trains/1
+2021/02/22 08:02:45 train 1 removed by dev-client
+
+// without a client certificate the handshake fails
+$ curl -s --cacert ca.pem https://localhost:8080/v1/trains/1
+2021/02/22 08:02:52 http: TLS handshake error from 127.0.0.1:59966: tls: client didn't provide a certificate
+
+// real certificates are reloaded when the files change, clients are verified against the given CAs
+$ TRAIN_API_CLIENT_CA=/etc/pki/internal-ca.pem go run . -addr :8443 -tls-cert /etc/pki/train.pem -tls-key /etc/pki/train-key.pem -client-auth require
 */
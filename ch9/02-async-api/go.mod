@@ -7,4 +7,7 @@ require (
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/streadway/amqp v1.0.0
+	goweb/pkg v0.0.0
 )
+
+replace goweb/pkg => ../../pkg
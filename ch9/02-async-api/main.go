@@ -12,6 +12,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,6 +23,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/streadway/amqp"
+
+	"goweb/pkg/server"
 )
 
 // rabbitmq connection details
@@ -315,6 +318,12 @@ func getServer() JobServer {
 }
 
 func main() {
+	cfg := server.Defaults()
+	if err := cfg.RegisterFlags(flag.CommandLine, "ASYNC_API_"); err != nil {
+		log.Fatalln(err)
+	}
+	flag.Parse()
+
 	jobServer := getServer()
 
 	go func(conn JobServer) {
@@ -331,17 +340,14 @@ func main() {
 	router.HandleFunc("/job/callback", jobServer.asyncCallbackHandler)
 	router.HandleFunc("/job/status", jobServer.statusHandler)
 
-	httpServer := &http.Server{
-		Handler:      router,
-		Addr:         "localhost:8080",
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	// close opened resources once requests are drained, hooks run in reverse order
+	srv := server.New(cfg, router)
+	srv.OnShutdown("redis", server.Close(jobServer.RedisClient))
+	srv.OnShutdown("rabbitmq connection", server.Close(jobServer.RabbitConn))
+	srv.OnShutdown("rabbitmq channel", server.Close(jobServer.Channel))
+	if err := srv.Run(); err != nil {
+		log.Fatalln(err)
 	}
-	log.Fatal(httpServer.ListenAndServe())
-
-	// close opened resources
-	defer jobServer.Channel.Close()
-	defer jobServer.RabbitConn.Close()
 }
 
 /*
@@ -414,4 +420,11 @@ Date: Fri, 26 Feb 2021 13:59:12 GMT
 Content-Length: 61
 
 {"ID":"55972329-8b4e-4706-a814-648e9112bc12","Status":"DONE"}
+
+# server settings come from flags or ASYNC_API_* env variables, like ASYNC_API_ADDR=:9090
+# ctrl-c (or SIGTERM) drains requests in flight and then closes queue and cache connections
+^C2021/02/26 14:01:10 shutting down, draining requests for up to 15s
+2021/02/26 14:01:10 closed rabbitmq channel
+2021/02/26 14:01:10 closed rabbitmq connection
+2021/02/26 14:01:10 closed redis
 */
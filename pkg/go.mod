@@ -0,0 +1,3 @@
+module goweb/pkg
+
+go 1.16
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	const limit = 10
+	// read answers the size of the body or 400 with the read error
+	read := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, len(data))
+	})
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64 // -1 for chunked
+		status        int
+		response      string
+	}{
+		{"empty", "", 0, http.StatusOK, "0"},
+		{"at the limit", strings.Repeat("x", limit), limit, http.StatusOK, "10"},
+		{"declared over the limit", strings.Repeat("x", limit+1), limit + 1, http.StatusRequestEntityTooLarge,
+			`{"error":"request body over 10 bytes"}` + "\n"},
+		{"chunked at the limit", strings.Repeat("x", limit), -1, http.StatusOK, "10"},
+		{"chunked over the limit", strings.Repeat("x", limit+1), -1, http.StatusBadRequest,
+			"http: request body too large\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+			rec := httptest.NewRecorder()
+			MaxBodySize(limit)(read).ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Body.String() != tt.response {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.response)
+			}
+		})
+	}
+}
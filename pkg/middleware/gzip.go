@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the smallest response worth compressing, the gzip header and footer alone take 18 bytes
+const gzipMinSize = 512
+
+// incompressible lists content type prefixes that are compressed already
+var incompressible = []string{"image/", "video/", "audio/", "font/woff", "application/zip", "application/gzip", "application/x-gzip", "application/octet-stream"}
+
+// Gzip compresses responses of clients accepting gzip with level, like gzip.DefaultCompression.
+// small responses, already encoded ones and compressed media types are sent as they are.
+// an invalid level falls back to the default. upgrade requests like websockets are passed through.
+func Gzip(level int) func(http.Handler) http.Handler {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, level)
+		return gz
+	}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Header.Get("Range") != "" || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, pool: pool, head: r.Method == http.MethodHead}
+			next.ServeHTTP(gw, r)
+			// not deferred, after a panic nothing buffered may go out so Recover can still answer 500
+			gw.close()
+		})
+	}
+}
+
+// acceptsGzip checks Accept-Encoding for gzip without q=0, * counts only when gzip isn't listed
+func acceptsGzip(header string) bool {
+	gzipQ, starQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "gzip" {
+			gzipQ = q
+		} else {
+			starQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
+
+// isUpgrade tells whether the client asks to switch protocols, the connection isn't http afterwards
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipWriter holds the response back until gzipMinSize bytes are written or the handler is done,
+// then decides whether to compress it
+type gzipWriter struct {
+	http.ResponseWriter
+	pool *sync.Pool
+	head bool
+
+	code    int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code) // informational responses go out right away
+		return
+	}
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.decided {
+		return w.write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= gzipMinSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been written so far, compressed data included
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over, only before anything has been written as it bypasses the compressor
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("middleware: %T doesn't support hijacking", w.ResponseWriter)
+	}
+	if w.code != 0 {
+		return nil, nil, fmt.Errorf("middleware: hijack after the response has been started")
+	}
+	w.decided = true // nothing is left for close to send
+	return h.Hijack()
+}
+
+// write passes p through the compressor if there is one
+func (w *gzipWriter) write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends headers, compressed when the response is worth it, and the buffered body
+func (w *gzipWriter) decide() error {
+	w.decided = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	h := w.Header()
+	// content type must be sniffed from the plain body, not the compressed one
+	if _, ok := h["Content-Type"]; !ok && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if w.compressible() {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.write(buf)
+	return err
+}
+
+// compressible tells whether the response should be compressed
+func (w *gzipWriter) compressible() bool {
+	h := w.Header()
+	if w.head || len(w.buf) < gzipMinSize || w.code == http.StatusNoContent || w.code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || strings.Contains(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range incompressible {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// close finishes the response once the handler has returned
+func (w *gzipWriter) close() {
+	if !w.decided {
+		if w.code == 0 {
+			return // nothing written, net/http sends the default response
+		}
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
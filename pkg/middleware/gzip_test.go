@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gunzip returns the decompressed body, failing the test on corrupt data
+func gunzip(t *testing.T, data []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gunzip: %s", err)
+	}
+	plain, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip: %s", err)
+	}
+	return string(plain)
+}
+
+func TestGzip(t *testing.T) {
+	large := strings.Repeat("compress me please ", 100)
+	small := strings.Repeat("x", gzipMinSize-1)
+	// respond writes body with the given headers and status
+	respond := func(status int, body string, header ...string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i+1 < len(header); i += 2 {
+				w.Header().Set(header[i], header[i+1])
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}
+	}
+	tests := []struct {
+		name        string
+		method      string
+		request     []string // request header pairs
+		handler     http.HandlerFunc
+		compressed  bool
+		status      int
+		contentType string
+	}{
+		{"text", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Content-Type", "text/plain"), true, 200, "text/plain"},
+		{"sniffed before compressing", "", []string{"Accept-Encoding", "gzip"}, respond(200, "<html>"+large), true, 200, "text/html; charset=utf-8"},
+		{"any coding", "", []string{"Accept-Encoding", "br;q=1, *;q=0.5"}, respond(200, large), true, 200, ""},
+		{"status kept", "", []string{"Accept-Encoding", "gzip"}, respond(http.StatusNotFound, large), true, http.StatusNotFound, ""},
+		{"content length dropped", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Content-Length", "1900"), true, 200, ""},
+		{"no accept encoding", "", nil, respond(200, large), false, 200, ""},
+		{"identity only", "", []string{"Accept-Encoding", "identity"}, respond(200, large), false, 200, ""},
+		{"q=0", "", []string{"Accept-Encoding", "gzip;q=0, identity"}, respond(200, large), false, 200, ""},
+		{"q=0 with spaces", "", []string{"Accept-Encoding", "GZIP ; q=0"}, respond(200, large), false, 200, ""},
+		{"range", "", []string{"Accept-Encoding", "gzip", "Range", "bytes=0-99"}, respond(200, large), false, 200, ""},
+		{"upgrade", "", []string{"Accept-Encoding", "gzip", "Connection", "keep-alive, Upgrade"}, respond(200, large), false, 200, ""},
+		{"head", http.MethodHead, []string{"Accept-Encoding", "gzip"}, respond(200, large), false, 200, ""},
+		{"no content", "", []string{"Accept-Encoding", "gzip"}, respond(http.StatusNoContent, ""), false, http.StatusNoContent, ""},
+		{"not modified", "", []string{"Accept-Encoding", "gzip"}, respond(http.StatusNotModified, ""), false, http.StatusNotModified, ""},
+		{"under the minimum size", "", []string{"Accept-Encoding", "gzip"}, respond(200, small, "Content-Type", "text/plain"), false, 200, "text/plain"},
+		{"image", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Content-Type", "image/png"), false, 200, "image/png"},
+		{"zip", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Content-Type", "application/zip"), false, 200, "application/zip"},
+		{"encoded already", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Content-Encoding", "br"), false, 200, ""},
+		{"no-transform", "", []string{"Accept-Encoding", "gzip"}, respond(200, large, "Cache-Control", "public, no-transform"), false, 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			for i := 0; i+1 < len(tt.request); i += 2 {
+				r.Header.Set(tt.request[i], tt.request[i+1])
+			}
+			rec := httptest.NewRecorder()
+			Gzip(gzip.DefaultCompression)(tt.handler).ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			h := rec.Header()
+			if h.Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", h.Get("Vary"))
+			}
+			if tt.contentType != "" && h.Get("Content-Type") != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", h.Get("Content-Type"), tt.contentType)
+			}
+			if !tt.compressed {
+				if h.Get("Content-Encoding") == "gzip" {
+					t.Fatal("compressed")
+				}
+				return
+			}
+			if h.Get("Content-Encoding") != "gzip" || h.Get("Content-Length") != "" {
+				t.Fatalf("headers = %v, want gzip without length", h)
+			}
+			if body := gunzip(t, rec.Body.Bytes()); !strings.HasSuffix(body, large) {
+				t.Errorf("body = %q", body)
+			}
+		})
+	}
+}
+
+func TestGzipPassThrough(t *testing.T) {
+	// bodies the handler writes in pieces must come out unchanged when they aren't compressed
+	tests := []struct {
+		name   string
+		pieces []string
+	}{
+		{"nothing", nil},
+		{"small pieces", []string{"a", "b", "c"}},
+		{"crossing the minimum size", []string{strings.Repeat("x", 300), strings.Repeat("y", 300)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Gzip(gzip.BestSpeed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				for _, p := range tt.pieces {
+					w.Write([]byte(p))
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if want := strings.Join(tt.pieces, ""); rec.Body.String() != want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestGzipFlush(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      string
+		compressed bool
+	}{
+		{"flushed before the minimum size", "event: ping\n\n", false},
+		{"flushed after the minimum size", strings.Repeat("data: tick\n\n", 100), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h := Gzip(gzip.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Write([]byte(tt.first))
+				w.(http.Flusher).Flush()
+				if !rec.Flushed || rec.Body.Len() == 0 {
+					t.Error("nothing reached the client on flush")
+				}
+				if tt.compressed {
+					// a sync flush makes everything written so far decodable
+					zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					got := make([]byte, len(tt.first))
+					if _, err := io.ReadFull(zr, got); err != nil || string(got) != tt.first {
+						t.Errorf("flushed %q, %v", got, err)
+					}
+				}
+				w.Write([]byte("data: last\n\n"))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			h.ServeHTTP(rec, r)
+
+			body := rec.Body.String()
+			if tt.compressed {
+				body = gunzip(t, rec.Body.Bytes())
+			}
+			if want := tt.first + "data: last\n\n"; body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+			if (rec.Header().Get("Content-Encoding") == "gzip") != tt.compressed {
+				t.Errorf("Content-Encoding = %q", rec.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestGzipHijack(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"upgrade request skips the wrapper", http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}, "Accept-Encoding": {"gzip"}}},
+		{"through the wrapper", http.Header{"Accept-Encoding": {"gzip"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Gzip(gzip.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hijackResponse(t, w, "hijacked")
+			}))
+			if body := getBody(t, h, tt.header); body != "hijacked" {
+				t.Errorf("body = %q, want %q", body, "hijacked")
+			}
+		})
+	}
+
+	// once the response has started the connection isn't the handler's to take
+	h := Gzip(gzip.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("started"))
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			t.Error("hijacked a started response")
+		}
+	}))
+	if body := getBody(t, h, http.Header{"Accept-Encoding": {"gzip"}}); body != "started" {
+		t.Errorf("body = %q, want %q", body, "started")
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.1", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.000", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*, gzip;q=0", false},
+		{"*;q=0, gzip", true},
+		{"*;q=0, gzip;q=0.5", true},
+		{"x-gzip", false},
+		{"br, deflate", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Package middleware provides handler wrappers meant for production use, each constructor returns
+// func(http.Handler) http.Handler so they chain with alice:
+//
+//	alice.New(middleware.RequestID, middleware.Recover(nil), middleware.Gzip(gzip.DefaultCompression)).Then(h)
+//
+// errors are answered with json bodies like {"error": "...", "request_id": "..."}.
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse responce struct
+type errorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError writes status with a json error body mentioning the request id
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data, _ := json.Marshal(errorResponse{message, RequestIDFrom(r.Context())})
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces r.RemoteAddr with the client address reported by trusted proxies, given as
+// CIDRs or single addresses. X-Forwarded-For is read right to left skipping trusted hops, so
+// addresses made up by the client in front of the first proxy are ignored. X-Real-IP is used when
+// X-Forwarded-For is missing. requests of untrusted peers are left as they are.
+func RealIP(trusted ...string) (func(http.Handler) http.Handler, error) {
+	nets := make([]*net.IPNet, 0, len(trusted))
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, fmt.Errorf("realip: invalid address %q", t)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("realip: %s", err)
+		}
+		nets = append(nets, n)
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := clientIP(r, isTrusted); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// clientIP returns address of the client behind trusted proxies, nil if the peer isn't trusted
+// or doesn't tell
+func clientIP(r *http.Request, isTrusted func(net.IP) bool) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrusted(peer) {
+		return nil
+	}
+
+	// several headers are a single comma separated list
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage can't be traced further back
+		}
+		client = ip
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	realIP, err := RealIP("10.0.0.0/8", "192.168.1.1", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		xRealIP string
+		want    string
+	}{
+		{"untrusted peer", "203.0.113.9:1234", []string{"1.2.3.4"}, "", "203.0.113.9:1234"},
+		{"untrusted peer next to a trusted one", "192.168.1.2:1234", []string{"1.2.3.4"}, "", "192.168.1.2:1234"},
+		{"trusted peer without headers", "10.0.0.1:1234", nil, "", "10.0.0.1:1234"},
+		{"single address", "192.168.1.1:1234", []string{"1.2.3.4"}, "", "1.2.3.4"},
+		{"one proxy", "10.0.0.1:1234", []string{"1.2.3.4"}, "", "1.2.3.4"},
+		{"spoofed hops are skipped", "10.0.0.1:1234", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "", "1.2.3.4"},
+		{"several headers", "10.0.0.1:1234", []string{"6.6.6.6", "1.2.3.4", "10.0.0.2"}, "", "1.2.3.4"},
+		{"all hops trusted", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"garbage stops the walk", "10.0.0.1:1234", []string{"1.2.3.4, unknown, 10.0.0.2"}, "", "10.0.0.2"},
+		{"garbage right away", "10.0.0.1:1234", []string{"1.2.3.4, unknown"}, "", "10.0.0.1:1234"},
+		{"forwarded for wins over real ip", "10.0.0.1:1234", []string{"1.2.3.4"}, "5.6.7.8", "1.2.3.4"},
+		{"real ip fallback", "10.0.0.1:1234", nil, "5.6.7.8", "5.6.7.8"},
+		{"invalid real ip", "10.0.0.1:1234", nil, "nope", "10.0.0.1:1234"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "", "2001:db8::7"},
+		{"untrusted ipv6 peer", "[2001:db8::1]:1234", []string{"1.2.3.4"}, "", "[2001:db8::1]:1234"},
+		{"peer without port", "10.0.0.1", []string{"1.2.3.4"}, "", "1.2.3.4"},
+		{"unparsable peer", "somewhere", []string{"1.2.3.4"}, "", "somewhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header["X-Forwarded-For"] = tt.xff
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRealIPInvalid(t *testing.T) {
+	for _, trusted := range []string{"10.0.0.0/33", "10.0.0/8", "localhost", "300.1.1.1", ""} {
+		if _, err := RealIP("127.0.0.1", trusted); err == nil {
+			t.Errorf("RealIP(%q) didn't fail", trusted)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns panics of next into a json 500 and logs them with the stack to logger,
+// the standard logger if nil. http.ErrAbortHandler is passed on, it's meant to abort the response.
+func Recover(logger *log.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &headerWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				stack := debug.Stack()
+				if p, ok := v.(handlerPanic); ok {
+					v, stack = p.value, p.stack
+				}
+				logger.Printf("panic: %s %s request_id=%s: %v\n%s", r.Method, r.URL.Path, RequestIDFrom(r.Context()), v, stack)
+				if rw.wroteHeader {
+					// too late for an error response, cut the connection so the client sees it failed
+					panic(http.ErrAbortHandler)
+				}
+				writeError(w, r, http.StatusInternalServerError, "internal server error")
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// headerWriter remembers whether the response has been started
+type headerWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers work through the wrapper
+func (w *headerWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket handlers take the connection over, the response counts as started then
+func (w *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("middleware: %T doesn't support hijacking", w.ResponseWriter)
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serve runs h on r and returns the recorder along with what h panicked with, if anything
+func serve(h http.Handler, r *http.Request) (rec *httptest.ResponseRecorder, panicked interface{}) {
+	rec = httptest.NewRecorder()
+	defer func() { panicked = recover() }()
+	h.ServeHTTP(rec, r)
+	return rec, nil
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		repanic interface{}
+		logged  string
+	}{
+		{
+			name:    "no panic",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:    "panic before the response",
+			handler: func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			status:  http.StatusInternalServerError,
+			body:    `{"error":"internal server error","request_id":"req-1"}` + "\n",
+			logged:  "panic: GET /path request_id=req-1: boom",
+		},
+		{
+			name: "headers of the handler are dropped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Header().Set("Content-Length", "100")
+				panic("boom")
+			},
+			status: http.StatusInternalServerError,
+			body:   `{"error":"internal server error","request_id":"req-1"}` + "\n",
+			logged: "boom",
+		},
+		{
+			name:    "abort handler is passed on",
+			handler: func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			repanic: http.ErrAbortHandler,
+		},
+		{
+			name: "panic after the header aborts",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("late")
+			},
+			repanic: http.ErrAbortHandler,
+			logged:  "late",
+		},
+		{
+			name: "panic after the body aborts",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("partial"))
+				panic("late")
+			},
+			repanic: http.ErrAbortHandler,
+			logged:  "late",
+		},
+		{
+			name: "panic after a flush aborts",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				panic("late")
+			},
+			repanic: http.ErrAbortHandler,
+			logged:  "late",
+		},
+		{
+			name: "panic of another goroutine logs its stack",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic(handlerPanic{"timed", []byte("goroutine 42 [running]")})
+			},
+			status: http.StatusInternalServerError,
+			body:   `{"error":"internal server error","request_id":"req-1"}` + "\n",
+			logged: "timed\ngoroutine 42 [running]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			h := RequestID(Recover(log.New(&logs, "", 0))(tt.handler))
+			r := httptest.NewRequest(http.MethodGet, "/path", nil)
+			r.Header.Set(RequestIDHeader, "req-1")
+
+			rec, panicked := serve(h, r)
+			if panicked != tt.repanic {
+				t.Fatalf("panicked with %v, want %v", panicked, tt.repanic)
+			}
+			if !strings.Contains(logs.String(), tt.logged) || (tt.logged == "") != (logs.Len() == 0) {
+				t.Errorf("log = %q, want it to contain %q", logs.String(), tt.logged)
+			}
+			if tt.repanic != nil {
+				return
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if tt.status == http.StatusInternalServerError {
+				h := rec.Header()
+				if h.Get("Content-Type") != "application/json" || h.Get("Content-Encoding") != "" || h.Get("Content-Length") != "" {
+					t.Errorf("headers = %v", h)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverHijack(t *testing.T) {
+	hijack := func(w http.ResponseWriter, r *http.Request) {
+		hijackResponse(t, w, "hijacked")
+	}
+	h := Recover(log.New(ioutil.Discard, "", 0))(http.HandlerFunc(hijack))
+	if body := getBody(t, h, nil); body != "hijacked" {
+		t.Errorf("body = %q, want %q", body, "hijacked")
+	}
+
+	// a recorder can't be hijacked, the wrapper must say so instead of panicking
+	rec, panicked := serve(Recover(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			t.Error("hijacking a recorder succeeded")
+		}
+	})), httptest.NewRequest(http.MethodGet, "/", nil))
+	if panicked != nil || rec.Code != http.StatusOK {
+		t.Errorf("panicked = %v, status = %d", panicked, rec.Code)
+	}
+}
+
+// hijackResponse takes the connection of w over and answers body on it by hand
+func hijackResponse(t *testing.T, w http.ResponseWriter, body string) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		t.Errorf("%T isn't a hijacker", w)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		t.Errorf("hijack: %s", err)
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Type: text/plain\r\n\r\n" + body)
+	rw.Flush()
+}
+
+// getBody requests h served by a real server, which can be hijacked unlike a recorder
+func getBody(t *testing.T, h http.Handler, header http.Header) string {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request id in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen limits ids accepted from clients, longer ones are replaced
+const maxRequestIDLen = 128
+
+// requestIDKey is the context key of the request id
+type requestIDKey struct{}
+
+// RequestID keeps a well formed X-Request-ID sent by the client or a proxy and generates one otherwise.
+// the id is put into the request context and echoed in the response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom returns id of the request, empty if RequestID isn't in the chain
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// PropagateRequestID copies the request id of ctx to an outgoing request,
+// so logs of services called while handling a request can be matched up
+func PropagateRequestID(ctx context.Context, out *http.Request) {
+	if id := RequestIDFrom(ctx); id != "" {
+		out.Header.Set(RequestIDHeader, id)
+	}
+}
+
+// newRequestID returns 128 random bits in hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts ids of letters, digits and a few separators only,
+// they end up in logs and response headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name     string
+		incoming []string
+		kept     bool
+	}{
+		{"missing", nil, false},
+		{"empty", []string{""}, false},
+		{"uuid", []string{"0f8fad5b-d9cb-469f-a165-70867728950e"}, true},
+		{"separators", []string{"svc.api:v1/req_7+a=="}, true},
+		{"longest", []string{strings.Repeat("a", maxRequestIDLen)}, true},
+		{"too long", []string{strings.Repeat("a", maxRequestIDLen+1)}, false},
+		{"space", []string{"abc def"}, false},
+		{"header injection", []string{"abc\r\nSet-Cookie: x=1"}, false},
+		{"non ascii", []string{"abcé"}, false},
+		{"first of several", []string{"first", "second"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen, forwarded string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFrom(r.Context())
+				forwarded = r.Header.Get(RequestIDHeader)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, id := range tt.incoming {
+				r.Header.Add(RequestIDHeader, id)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if seen != echoed || forwarded != echoed {
+				t.Fatalf("context id %q, request header %q, response header %q differ", seen, forwarded, echoed)
+			}
+			if tt.kept && echoed != tt.incoming[0] {
+				t.Errorf("id = %q, want %q kept", echoed, tt.incoming[0])
+			}
+			if !tt.kept && !generated.MatchString(echoed) {
+				t.Errorf("id = %q, want a generated one", echoed)
+			}
+		})
+	}
+}
+
+func TestRequestIDUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		id := rec.Header().Get(RequestIDHeader)
+		if seen[id] {
+			t.Fatalf("id %q generated twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestPropagateRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"id of the request", context.WithValue(context.Background(), requestIDKey{}, "req-1"), "req-1"},
+		{"no id", context.Background(), "outgoing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := httptest.NewRequest(http.MethodGet, "http://backend/", nil)
+			out.Header.Set(RequestIDHeader, "outgoing")
+			PropagateRequestID(tt.ctx, out)
+			if got := out.Header.Get(RequestIDHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", RequestIDHeader, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Timeout answers 503 with a json error when next takes longer than d. next gets a request context
+// that is cancelled at the deadline and should return soon after, its writes fail from then on.
+// like http.TimeoutHandler the response is buffered, so it doesn't suit streaming routes.
+// panics of next are raised again in the serving goroutine, put Recover in front to handle them.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					v := recover()
+					if v == nil {
+						return
+					}
+					if v != http.ErrAbortHandler {
+						v = handlerPanic{v, debug.Stack()}
+					}
+					panicked <- v
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case v := <-panicked:
+				panic(v)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				dst := w.Header()
+				for k, v := range tw.header {
+					dst[k] = v
+				}
+				if tw.code == 0 {
+					tw.code = http.StatusOK
+				}
+				w.WriteHeader(tw.code)
+				w.Write(tw.buf.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					writeError(w, r, http.StatusServiceUnavailable, fmt.Sprintf("request timed out after %s", d))
+				}
+				// otherwise the client has gone away and there is nobody to answer
+			}
+		})
+	}
+}
+
+// handlerPanic carries a panic of a handler run in another goroutine along with its stack,
+// Recover logs the stack instead of its own which doesn't show the handler
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p handlerPanic) String() string { return fmt.Sprint(p.value) }
+
+// timeoutWriter buffers the response until the handler returns in time
+type timeoutWriter struct {
+	header http.Header
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 || code < 200 {
+		return
+	}
+	tw.code = code
+}
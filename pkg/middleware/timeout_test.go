@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	const d = 20 * time.Millisecond
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		header  string // expected X-Test header
+	}{
+		{
+			name: "buffered success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "kept")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("created"))
+				w.WriteHeader(http.StatusTeapot) // ignored like net/http does
+			},
+			status: http.StatusCreated,
+			body:   "created",
+			header: "kept",
+		},
+		{
+			name:    "implicit ok",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			status:  http.StatusOK,
+			body:    "ok",
+		},
+		{
+			name:    "nothing written",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			status:  http.StatusOK,
+		},
+		{
+			name: "deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "lost")
+				w.Write([]byte("partial"))
+				<-r.Context().Done()
+			},
+			status: http.StatusServiceUnavailable,
+			body:   `{"error":"request timed out after 20ms","request_id":"req-1"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequestID(Timeout(d)(tt.handler))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(RequestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if got := rec.Header().Get("X-Test"); got != tt.header {
+				t.Errorf("X-Test = %q, want %q", got, tt.header)
+			}
+		})
+	}
+}
+
+func TestTimeoutLateWrites(t *testing.T) {
+	type result struct {
+		n   int
+		err error
+	}
+	late := make(chan result, 1)
+	answered := make(chan struct{})
+	h := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		<-answered // the context is done a moment before the 503 goes out
+		w.WriteHeader(http.StatusOK)
+		n, err := w.Write([]byte("too late"))
+		late <- result{n, err}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	close(answered)
+
+	res := <-late
+	if res.n != 0 || res.err != http.ErrHandlerTimeout {
+		t.Errorf("late write = %d, %v, want 0, %v", res.n, res.err, http.ErrHandlerTimeout)
+	}
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "too late") {
+		t.Errorf("response = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := Timeout(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Errorf("answered a gone client: %v %q", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"value", "boom"},
+		{"abort handler", http.ErrAbortHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("partial"))
+				panic(tt.value)
+			}))
+			rec, panicked := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Body.Len() != 0 {
+				t.Errorf("buffered body %q sent after a panic", rec.Body.String())
+			}
+			if tt.value == http.ErrAbortHandler {
+				if panicked != http.ErrAbortHandler {
+					t.Errorf("panicked with %v, want %v", panicked, http.ErrAbortHandler)
+				}
+				return
+			}
+			p, ok := panicked.(handlerPanic)
+			if !ok || p.value != tt.value || !bytes.Contains(p.stack, []byte("TestTimeoutPanic")) {
+				t.Errorf("panicked with %#v, want the value with the stack of the handler", panicked)
+			}
+		})
+	}
+
+	// Recover in front answers 500 and logs the stack of the handler goroutine
+	var logs bytes.Buffer
+	h := Recover(log.New(&logs, "", 0))(Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	rec, panicked := serve(h, httptest.NewRequest(http.MethodGet, "/", nil))
+	if panicked != nil || rec.Code != http.StatusInternalServerError {
+		t.Errorf("panicked = %v, status = %d", panicked, rec.Code)
+	}
+	if !strings.Contains(logs.String(), ": boom\n") || !strings.Contains(logs.String(), "TestTimeoutPanic") {
+		t.Errorf("log = %q", logs.String())
+	}
+}
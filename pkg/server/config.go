@@ -0,0 +1,143 @@
+// Package server runs http handlers of the examples with configurable listeners, timeouts, TLS and mTLS,
+// draining connections and closing registered resources on SIGINT or SIGTERM.
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds everything needed to start a server, zero durations disable the matching timeout
+type Config struct {
+	Addr              string        // tcp address, empty serves only unix socket and systemd listeners
+	ReadTimeout       time.Duration // whole request including body
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration // keep-alive connections
+	ShutdownTimeout   time.Duration // how long in-flight requests and shutdown hooks may take
+	MaxHeaderBytes    int
+	TLSCert           string // certificate and key files, both enable https on every listener, reloaded on change
+	TLSKey            string
+	DevTLS            bool        // serve https with a certificate of a generated local CA, for development only
+	DevTLSDir         string      // where the dev CA and certificates are cached, user cache dir if empty
+	ClientAuth        string      // none, optional or require client certificates signed by ClientCAs
+	ClientCAs         []string    // pem files of CAs trusted to sign client certificates, the dev CA if empty with DevTLS
+	Socket            string      // unix socket path, empty disables it
+	SocketMode        os.FileMode // permissions of the unix socket
+	Systemd           bool        // serve sockets passed by systemd socket activation too
+}
+
+// Defaults returns config used by the examples so far, localhost:8080 with 15s timeouts
+func Defaults() Config {
+	return Config{
+		Addr:              "localhost:8080",
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		ShutdownTimeout:   15 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ClientAuth:        "none",
+		SocketMode:        0660,
+		Systemd:           true,
+	}
+}
+
+// RegisterFlags defines flags for every field of c with the current values as defaults.
+// environment variables named envPrefix + flag name in upper case with dashes turned into
+// underscores, like APP_READ_TIMEOUT for read-timeout, override defaults, flags override both.
+func (c *Config) RegisterFlags(fs *flag.FlagSet, envPrefix string) error {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "tcp listen address (empty disables tcp)")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "max duration of reading a whole request (0 disables)")
+	fs.DurationVar(&c.ReadHeaderTimeout, "read-header-timeout", c.ReadHeaderTimeout, "max duration of reading request headers (0 disables)")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "max duration of writing a response (0 disables)")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "how long keep-alive connections wait for the next request (0 disables)")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to drain requests and run shutdown hooks on SIGINT or SIGTERM")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "max size of request headers")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "tls certificate file, serves https together with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "tls private key file")
+	fs.BoolVar(&c.DevTLS, "dev-tls", c.DevTLS, "serve https with a generated self-signed CA and certificate (development only)")
+	fs.StringVar(&c.DevTLSDir, "dev-tls-dir", c.DevTLSDir, "directory caching the dev CA and certificates (default user cache dir)")
+	fs.StringVar(&c.ClientAuth, "client-auth", c.ClientAuth, "client certificates: none, optional (verified if sent) or require")
+	fs.Var((*fileList)(&c.ClientCAs), "client-ca", "pem file of CAs signing client certificates, comma separated or repeated")
+	fs.StringVar(&c.Socket, "socket", c.Socket, "unix socket path to listen on (empty disables)")
+	fs.Var((*fileMode)(&c.SocketMode), "socket-mode", "permissions of the unix socket")
+	fs.BoolVar(&c.Systemd, "systemd", c.Systemd, "serve sockets passed by systemd socket activation")
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("%s: %s", name, serr)
+		}
+	})
+	return err
+}
+
+// Validate checks settings that depend on each other
+func (c Config) Validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls-cert and tls-key must be set together")
+	}
+	if c.DevTLS && c.TLSCert != "" {
+		return fmt.Errorf("dev-tls and tls-cert can't be used together")
+	}
+	switch c.ClientAuth {
+	case "", "none":
+		if len(c.ClientCAs) > 0 {
+			return fmt.Errorf("client-ca needs client-auth optional or require")
+		}
+	case "optional", "require":
+		if c.TLSCert == "" && !c.DevTLS {
+			return fmt.Errorf("client-auth needs tls-cert or dev-tls")
+		}
+		if len(c.ClientCAs) == 0 && !c.DevTLS {
+			return fmt.Errorf("client-auth needs client-ca")
+		}
+	default:
+		return fmt.Errorf("client-auth must be none, optional or require")
+	}
+	if c.Addr == "" && c.Socket == "" && !c.Systemd {
+		return fmt.Errorf("nothing to listen on, set addr, socket or systemd")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown-timeout must be positive")
+	}
+	return nil
+}
+
+// fileMode is an octal flag value
+type fileMode os.FileMode
+
+func (m *fileMode) String() string { return fmt.Sprintf("%#o", uint32(*m)) }
+
+func (m *fileMode) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return fmt.Errorf("must be octal permissions like 0660")
+	}
+	*m = fileMode(v)
+	return nil
+}
+
+// fileList is a comma separated flag value, repeating the flag appends
+type fileList []string
+
+func (l *fileList) String() string { return strings.Join(*l, ",") }
+
+func (l *fileList) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
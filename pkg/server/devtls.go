@@ -0,0 +1,221 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// files of the dev CA, server and client certificates inside the dev tls directory
+const (
+	devCAFile         = "ca.pem"
+	devCAKeyFile      = "ca-key.pem"
+	devServerFile     = "server.pem"
+	devServerKeyFile  = "server-key.pem"
+	devClientFile     = "client.pem"
+	devClientKeyFile  = "client-key.pem"
+	devClientName     = "dev-client"
+	devCertRenewAhead = 7 * 24 * time.Hour // certificates expiring sooner are issued again
+)
+
+// devTLS is a local CA generated on first start and the certificates it issued, cached in dir
+type devTLS struct {
+	dir    string
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	issued bool // whether the CA was created just now, certificates of an older CA are useless then
+}
+
+// devCertificates makes sure dir holds the dev CA, a server certificate valid for hosts and
+// a client certificate for trying mTLS out, creating or renewing whatever is missing or stale
+func devCertificates(dir string, hosts []string) (*devTLS, error) {
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("dev-tls: %s, set dev-tls-dir", err)
+		}
+		dir = filepath.Join(cache, "goweb", "dev-tls")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("dev-tls: %s", err)
+	}
+
+	d := &devTLS{dir: dir}
+	if err := d.loadOrCreateCA(); err != nil {
+		return nil, fmt.Errorf("dev-tls: %s", err)
+	}
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+	if err := d.ensure(devServerFile, devServerKeyFile, server, hosts); err != nil {
+		return nil, fmt.Errorf("dev-tls: %s", err)
+	}
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: devClientName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err := d.ensure(devClientFile, devClientKeyFile, client, nil); err != nil {
+		return nil, fmt.Errorf("dev-tls: %s", err)
+	}
+	return d, nil
+}
+
+// path returns full name of a file in the dev tls directory
+func (d *devTLS) path(name string) string { return filepath.Join(d.dir, name) }
+
+// loadOrCreateCA reads the cached CA, a new one is generated if it's missing or about to expire
+func (d *devTLS) loadOrCreateCA() error {
+	ca, key, err := loadPair(d.path(devCAFile), d.path(devCAKeyFile))
+	if err == nil && time.Until(ca.NotAfter) > devCertRenewAhead {
+		d.ca, d.caKey = ca, key
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("dev-tls: replacing unusable CA: %s", err)
+	}
+
+	template := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"goweb development CA"}, CommonName: "goweb dev CA"},
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	// self-signed: the template is its own parent, the key is created inside issue
+	ca, key, err = issue(template, nil, nil, d.path(devCAFile), d.path(devCAKeyFile))
+	if err != nil {
+		return err
+	}
+	d.ca, d.caKey, d.issued = ca, key, true
+	log.Printf("dev-tls: created CA %s, trust it with curl --cacert or by adding it to the system store", d.path(devCAFile))
+	return nil
+}
+
+// ensure issues certificate of template signed by the dev CA unless a cached one is still good for hosts
+func (d *devTLS) ensure(certFile, keyFile string, template *x509.Certificate, hosts []string) error {
+	certFile, keyFile = d.path(certFile), d.path(keyFile)
+	if !d.issued {
+		if cert, _, err := loadPair(certFile, keyFile); err == nil && d.current(cert, hosts) {
+			return nil
+		}
+	}
+	template.NotAfter = time.Now().AddDate(1, 0, 0)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, _, err := issue(template, d.ca, d.caKey, certFile, keyFile); err != nil {
+		return err
+	}
+	log.Printf("dev-tls: issued %s for %s", certFile, template.Subject.CommonName)
+	return nil
+}
+
+// current tells whether cert was signed by the dev CA, isn't expiring soon and covers all hosts
+func (d *devTLS) current(cert *x509.Certificate, hosts []string) bool {
+	if cert.CheckSignatureFrom(d.ca) != nil || time.Until(cert.NotAfter) < devCertRenewAhead {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// issue creates a key and a certificate of template signed by parent, self-signed if parent is nil,
+// and writes both pem encoded, the key readable by the owner only
+func issue(template, parent *x509.Certificate, parentKey crypto.Signer, certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour) // tolerate clocks a bit behind
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// key goes first so a certificate never exists without its key
+	if err := writePEM(keyFile, "PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// loadPair reads a pem certificate with its private key
+func loadPair(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key type", keyFile)
+	}
+	return cert, key, nil
+}
+
+// writePEM replaces file with a single pem block through a temporary file, readers never see it half written.
+// the temporary file gets a unique name so servers starting at the same time don't write into each other's.
+func writePEM(name, blockType string, der []byte, mode os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	if err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listen opens systemd, tcp and unix socket listeners, whichever are configured
+func (s *Server) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	if s.cfg.Systemd {
+		activated, err := systemdListeners()
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, activated...)
+	}
+	if s.cfg.Addr != "" {
+		l, err := net.Listen("tcp", s.cfg.Addr)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if s.cfg.Socket != "" {
+		l, err := listenUnix(s.cfg.Socket, s.cfg.SocketMode)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket replacing a stale socket file left by a previous run.
+// the file is removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// listenFdsStart is the first file descriptor passed by systemd
+const listenFdsStart = 3
+
+// systemdListeners returns sockets passed by systemd socket activation, none if the process wasn't activated.
+// the environment is cleared so child processes don't pick the sockets up.
+func systemdListeners() ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("systemd: bad LISTEN_FDS %q", fds)
+	}
+
+	nameList := strings.Split(names, ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+		if i < len(nameList) && nameList[i] != "" {
+			name = nameList[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		// FileListener works on a duplicate, the inherited descriptor is closed right away
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd: socket %s: %s", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
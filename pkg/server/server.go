@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Server serves a handler on the configured listeners until SIGINT or SIGTERM
+type Server struct {
+	cfg  Config
+	HTTP *http.Server // may be adjusted before Run, like setting ErrorLog or TLSConfig base settings
+
+	mu    sync.Mutex
+	hooks []hook
+}
+
+// hook is a named shutdown function
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// New creates server of handler with the config, handlers find the verified client certificate
+// of mTLS requests with ClientIdentityFrom
+func New(cfg Config, handler http.Handler) *Server {
+	return &Server{
+		cfg: cfg,
+		HTTP: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           withClientIdentity(handler),
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
+	}
+}
+
+// OnShutdown registers fn to be called once requests are drained, like closing database or queue
+// connections. hooks run in reverse order of registration and share the shutdown deadline.
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook{name, fn})
+}
+
+// Close is a shutdown hook helper for things closed with a plain Close method
+func Close(c interface{ Close() error }) func(ctx context.Context) error {
+	return func(context.Context) error { return c.Close() }
+}
+
+// Run serves until a signal arrives or a listener fails, then drains requests and runs shutdown hooks.
+// it returns nil after a clean shutdown caused by a signal.
+func (s *Server) Run() error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	s.HTTP.TLSConfig = tlsConfig
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listeners, set addr or socket, or start with systemd socket activation")
+	}
+
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			var err error
+			if tlsConfig != nil {
+				log.Printf("listening on %s %s with tls", l.Addr().Network(), l.Addr())
+				// certificates come from tlsConfig.GetCertificate
+				err = s.HTTP.ServeTLS(l, "", "")
+			} else {
+				log.Printf("listening on %s %s", l.Addr().Network(), l.Addr())
+				err = s.HTTP.Serve(l)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}(l)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	var errs []string
+	select {
+	case <-ctx.Done():
+		log.Printf("shutting down, draining requests for up to %s", s.cfg.ShutdownTimeout)
+	case err := <-errc:
+		errs = append(errs, err.Error())
+		log.Printf("shutting down: %s", err)
+	}
+	// a second signal kills the process right away
+	stop()
+
+	return s.shutdown(errs)
+}
+
+// shutdown drains connections and runs hooks within the shutdown timeout
+func (s *Server) shutdown(errs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := s.HTTP.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("drain: %s", err))
+		s.HTTP.Close()
+	}
+
+	s.mu.Lock()
+	hooks := s.hooks
+	s.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", hooks[i].name, err))
+			continue
+		}
+		log.Printf("closed %s", hooks[i].name)
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
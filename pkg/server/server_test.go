@@ -0,0 +1,481 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTLS serves handler like Run does with tls settings of cfg, returning the https url
+func serveTLS(t *testing.T, cfg Config, handler http.Handler) string {
+	s := New(cfg, handler)
+	config, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HTTP.TLSConfig = config
+	// handshakes failing on purpose aren't worth logging
+	s.HTTP.ErrorLog = log.New(ioutil.Discard, "", 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.HTTP.ServeTLS(l, "", "")
+	t.Cleanup(func() { s.HTTP.Close() })
+	return "https://" + l.Addr().String()
+}
+
+// devClient trusts the dev CA of dir and presents the dev client certificate unless anonymous
+func devClient(t *testing.T, dir string, anonymous bool) *http.Client {
+	roots, err := loadCertPool([]string{filepath.Join(dir, devCAFile)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{RootCAs: roots}
+	if !anonymous {
+		pair, err := tls.LoadX509KeyPair(filepath.Join(dir, devClientFile), filepath.Join(dir, devClientKeyFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 5 * time.Second}
+}
+
+// whoami answers identity of the client certificate, null without one
+func whoami(w http.ResponseWriter, r *http.Request) {
+	id, _ := ClientIdentityFrom(r.Context())
+	json.NewEncoder(w).Encode(id)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		clientAuth string
+		anonymous  bool
+		wantCN     string // empty expects no identity
+		wantErr    bool
+	}{
+		{"optional with certificate", "optional", false, devClientName, false},
+		{"optional without certificate", "optional", true, "", false},
+		{"required with certificate", "require", false, devClientName, false},
+		{"required without certificate", "require", true, "", true},
+		// the certificate isn't asked for, so there is nothing verified
+		{"not asked for", "none", false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Defaults()
+			cfg.Addr, cfg.DevTLS, cfg.DevTLSDir, cfg.ClientAuth = "127.0.0.1:0", true, dir, tt.clientAuth
+			url := serveTLS(t, cfg, http.HandlerFunc(whoami))
+
+			resp, err := devClient(t, dir, tt.anonymous).Get(url)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request without a required client certificate succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var id *ClientIdentity
+			if err := json.NewDecoder(resp.Body).Decode(&id); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCN == "" {
+				if id != nil {
+					t.Errorf("identity = %+v, want none", id)
+				}
+				return
+			}
+			if id == nil {
+				t.Fatal("no client identity in the request context")
+			}
+			if id.CommonName != tt.wantCN || id.Subject != "CN="+tt.wantCN || !strings.Contains(id.Issuer, "CN=goweb dev CA") {
+				t.Errorf("identity = %+v", id)
+			}
+			if id.SerialNumber == "" || time.Until(id.NotAfter) < 300*24*time.Hour {
+				t.Errorf("identity = %+v, want serial and a year of validity", id)
+			}
+		})
+	}
+}
+
+func TestClientCertificateOfOtherCA(t *testing.T) {
+	trusted, other := t.TempDir(), t.TempDir()
+	if _, err := devCertificates(other, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Defaults()
+	cfg.Addr, cfg.DevTLS, cfg.DevTLSDir, cfg.ClientAuth = "127.0.0.1:0", true, trusted, "optional"
+	url := serveTLS(t, cfg, http.HandlerFunc(whoami))
+
+	client := devClient(t, trusted, true)
+	pair, err := tls.LoadX509KeyPair(filepath.Join(other, devClientFile), filepath.Join(other, devClientKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{pair}
+	if resp, err := client.Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatal("client certificate of an untrusted CA was accepted")
+	}
+}
+
+// writeSelfSigned writes a new self-signed certificate for name into the files
+func writeSelfSigned(t *testing.T, name, certFile, keyFile string) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		NotAfter:    time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if _, _, err := issue(template, nil, nil, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedName returns common name of the certificate the reloader hands out
+func servedName(t *testing.T, c *certReloader) string {
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, "first", certFile, keyFile)
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedName(t, c); got != "first" {
+		t.Fatalf("served %s, want first", got)
+	}
+
+	// touch sets modification times apart from the previous files on coarse clocks too
+	touch := func(at time.Time) {
+		for _, name := range []string{certFile, keyFile} {
+			if err := os.Chtimes(name, at, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeSelfSigned(t, "second", certFile, keyFile)
+	touch(time.Now().Add(time.Minute))
+	if got := servedName(t, c); got != "first" {
+		t.Errorf("served %s before the check interval passed, want first", got)
+	}
+	c.checked = time.Time{}
+	if got := servedName(t, c); got != "second" {
+		t.Errorf("served %s after the files changed, want second", got)
+	}
+
+	// a broken update keeps the previous certificate
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	touch(time.Now().Add(2 * time.Minute))
+	c.checked = time.Time{}
+	if got := servedName(t, c); got != "second" {
+		t.Errorf("served %s after a broken update, want second", got)
+	}
+	writeSelfSigned(t, "third", certFile, keyFile)
+	touch(time.Now().Add(3 * time.Minute))
+	c.checked = time.Time{}
+	if got := servedName(t, c); got != "third" {
+		t.Errorf("served %s after the fix, want third", got)
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("reloader of missing files was created")
+	}
+}
+
+// readFiles returns contents of the dev tls files, keyed by name
+func readFiles(t *testing.T, dir string) map[string][]byte {
+	files := make(map[string][]byte)
+	for _, name := range []string{devCAFile, devCAKeyFile, devServerFile, devServerKeyFile, devClientFile, devClientKeyFile} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = data
+	}
+	return files
+}
+
+// changed lists the dev tls files that differ between two reads
+func changed(before, after map[string][]byte) []string {
+	var names []string
+	for _, name := range []string{devCAFile, devCAKeyFile, devServerFile, devServerKeyFile, devClientFile, devClientKeyFile} {
+		if !bytes.Equal(before[name], after[name]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestDevCertificates(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1"}
+	d, err := devCertificates(dir, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.issued {
+		t.Error("CA of an empty directory wasn't created")
+	}
+	for name, mode := range map[string]os.FileMode{devCAKeyFile: 0600, devServerKeyFile: 0600, devClientKeyFile: 0600, devServerFile: 0644} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: mode %v, want %v", name, info.Mode().Perm(), mode)
+		}
+	}
+	server, _, err := loadPair(filepath.Join(dir, devServerFile), filepath.Join(dir, devServerKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Verify(x509.VerifyOptions{DNSName: "127.0.0.1", Roots: poolOf(d.ca)}); err != nil {
+		t.Errorf("server certificate: %s", err)
+	}
+	client, _, err := loadPair(filepath.Join(dir, devClientFile), filepath.Join(dir, devClientKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Verify(x509.VerifyOptions{Roots: poolOf(d.ca), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("client certificate: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T)
+		hosts   []string
+		changed []string
+	}{
+		{"cached", func(*testing.T) {}, hosts, nil},
+		{"new host", func(*testing.T) {}, []string{"localhost", "127.0.0.1", "dev.example"},
+			[]string{devServerFile, devServerKeyFile}},
+		{"server expiring", func(t *testing.T) {
+			d, err := devCertificates(dir, hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expiring := &x509.Certificate{
+				Subject:     pkix.Name{CommonName: "localhost"},
+				DNSNames:    []string{"localhost"},
+				IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+				NotAfter:    time.Now().Add(devCertRenewAhead / 2),
+			}
+			if _, _, err := issue(expiring, d.ca, d.caKey, d.path(devServerFile), d.path(devServerKeyFile)); err != nil {
+				t.Fatal(err)
+			}
+		}, hosts, []string{devServerFile, devServerKeyFile}},
+		{"client of another CA", func(t *testing.T) {
+			other := &x509.Certificate{Subject: pkix.Name{CommonName: devClientName}, NotAfter: time.Now().AddDate(1, 0, 0)}
+			if _, _, err := issue(other, nil, nil, filepath.Join(dir, devClientFile), filepath.Join(dir, devClientKeyFile)); err != nil {
+				t.Fatal(err)
+			}
+		}, hosts, []string{devClientFile, devClientKeyFile}},
+		// certificates of the old CA are issued again with the new one
+		{"CA expiring", func(t *testing.T) {
+			expiring := &x509.Certificate{
+				Subject:               pkix.Name{CommonName: "goweb dev CA"},
+				NotAfter:              time.Now().Add(devCertRenewAhead / 2),
+				KeyUsage:              x509.KeyUsageCertSign,
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+			}
+			if _, _, err := issue(expiring, nil, nil, filepath.Join(dir, devCAFile), filepath.Join(dir, devCAKeyFile)); err != nil {
+				t.Fatal(err)
+			}
+		}, hosts, []string{devCAFile, devCAKeyFile, devServerFile, devServerKeyFile, devClientFile, devClientKeyFile}},
+		{"CA unreadable", func(t *testing.T) {
+			if err := ioutil.WriteFile(filepath.Join(dir, devCAKeyFile), []byte("broken"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, hosts, []string{devCAFile, devCAKeyFile, devServerFile, devServerKeyFile, devClientFile, devClientKeyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// start from certificates current for hosts
+			if _, err := devCertificates(dir, hosts); err != nil {
+				t.Fatal(err)
+			}
+			tt.prepare(t)
+			before := readFiles(t, dir)
+			if _, err := devCertificates(dir, tt.hosts); err != nil {
+				t.Fatal(err)
+			}
+			got := changed(before, readFiles(t, dir))
+			if strings.Join(got, " ") != strings.Join(tt.changed, " ") {
+				t.Errorf("changed files %v, want %v", got, tt.changed)
+			}
+		})
+	}
+}
+
+// poolOf returns pool of a single certificate
+func poolOf(cert *x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+func TestDevHosts(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"localhost:8080", "localhost 127.0.0.1 ::1"},
+		{":8080", "localhost 127.0.0.1 ::1"},
+		{"0.0.0.0:8443", "localhost 127.0.0.1 ::1"},
+		{"10.0.0.5:8443", "localhost 127.0.0.1 ::1 10.0.0.5"},
+		{"dev.example:443", "localhost 127.0.0.1 ::1 dev.example"},
+		{"", "localhost 127.0.0.1 ::1"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(devHosts(tt.addr), " "); got != tt.want {
+			t.Errorf("devHosts(%q) = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"tls", func(c *Config) { c.TLSCert, c.TLSKey = "cert.pem", "key.pem" }, ""},
+		{"cert without key", func(c *Config) { c.TLSCert = "cert.pem" }, "must be set together"},
+		{"key without cert", func(c *Config) { c.TLSKey = "key.pem" }, "must be set together"},
+		{"dev tls with cert", func(c *Config) { c.DevTLS, c.TLSCert, c.TLSKey = true, "cert.pem", "key.pem" }, "can't be used together"},
+		{"client ca without client auth", func(c *Config) { c.ClientCAs = []string{"ca.pem"} }, "needs client-auth"},
+		{"client auth without tls", func(c *Config) { c.ClientAuth, c.ClientCAs = "require", []string{"ca.pem"} }, "needs tls-cert or dev-tls"},
+		{"client auth without ca", func(c *Config) { c.ClientAuth, c.TLSCert, c.TLSKey = "optional", "cert.pem", "key.pem" }, "needs client-ca"},
+		{"client auth with ca", func(c *Config) {
+			c.ClientAuth, c.TLSCert, c.TLSKey, c.ClientCAs = "require", "cert.pem", "key.pem", []string{"ca.pem"}
+		}, ""},
+		{"client auth with dev ca", func(c *Config) { c.ClientAuth, c.DevTLS = "require", true }, ""},
+		{"empty client auth", func(c *Config) { c.ClientAuth = "" }, ""},
+		{"unknown client auth", func(c *Config) { c.ClientAuth = "always" }, "must be none, optional or require"},
+		{"nothing to listen on", func(c *Config) { c.Addr, c.Systemd = "", false }, "nothing to listen on"},
+		{"socket only", func(c *Config) { c.Addr, c.Systemd, c.Socket = "", false, "app.sock" }, ""},
+		{"no shutdown timeout", func(c *Config) { c.ShutdownTimeout = 0 }, "shutdown-timeout must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Defaults()
+			tt.change(&c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// setenv sets environment variables for the test, restoring previous values afterwards
+func setenv(t *testing.T, env map[string]string) {
+	for name, value := range env {
+		name := name
+		prev, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, prev)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    func(c *Config)
+		wantErr string
+	}{
+		{"defaults", nil, nil, func(c *Config) {}, ""},
+		{"environment over defaults", map[string]string{
+			"TEST_READ_TIMEOUT": "3s",
+			"TEST_ADDR":         ":9090",
+			"TEST_DEV_TLS":      "true",
+			"TEST_CLIENT_CA":    "a.pem, b.pem",
+			"TEST_SOCKET_MODE":  "0600",
+			"OTHER_ADDR":        ":1",
+		}, nil, func(c *Config) {
+			c.ReadTimeout, c.Addr, c.DevTLS, c.ClientCAs, c.SocketMode = 3*time.Second, ":9090", true, []string{"a.pem", "b.pem"}, 0600
+		}, ""},
+		{"flags over environment", map[string]string{"TEST_READ_TIMEOUT": "3s", "TEST_ADDR": ":9090"},
+			[]string{"-read-timeout", "4s", "-systemd=false"}, func(c *Config) {
+				c.ReadTimeout, c.Addr, c.Systemd = 4*time.Second, ":9090", false
+			}, ""},
+		{"empty environment value", map[string]string{"TEST_ADDR": ""}, nil, func(c *Config) { c.Addr = "" }, ""},
+		{"bad environment value", map[string]string{"TEST_WRITE_TIMEOUT": "soon"}, nil, nil, "TEST_WRITE_TIMEOUT"},
+		{"bad socket mode", map[string]string{"TEST_SOCKET_MODE": "rw"}, nil, nil, "TEST_SOCKET_MODE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+			c := Defaults()
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			err := c.RegisterFlags(fs, "TEST_")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			want := Defaults()
+			tt.want(&want)
+			if !reflect.DeepEqual(c, want) {
+				t.Errorf("config =\n%+v\nwant\n%+v", c, want)
+			}
+		})
+	}
+}
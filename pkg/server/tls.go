@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval is how often certificate files are checked for changes, at most once per handshake
+const reloadCheckInterval = 5 * time.Second
+
+// tlsConfig returns tls settings of the config, nil when serving plain http
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.cfg.TLSCert == "" && !s.cfg.DevTLS {
+		return nil, nil
+	}
+	certFile, keyFile := s.cfg.TLSCert, s.cfg.TLSKey
+	clientCAs := s.cfg.ClientCAs
+	if s.cfg.DevTLS {
+		dev, err := devCertificates(s.cfg.DevTLSDir, devHosts(s.cfg.Addr))
+		if err != nil {
+			return nil, err
+		}
+		certFile, keyFile = dev.path(devServerFile), dev.path(devServerKeyFile)
+		if len(clientCAs) == 0 {
+			clientCAs = []string{dev.path(devCAFile)}
+		}
+		if s.cfg.ClientAuth == "optional" || s.cfg.ClientAuth == "require" {
+			log.Printf("dev-tls: client certificate for testing is %s with key %s", dev.path(devClientFile), dev.path(devClientKeyFile))
+		}
+	}
+
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.HTTP.TLSConfig != nil {
+		config = s.HTTP.TLSConfig.Clone()
+	}
+	config.GetCertificate = certs.GetCertificate
+
+	switch s.cfg.ClientAuth {
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return config, nil
+	}
+	config.ClientCAs, err = loadCertPool(clientCAs)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// devHosts lists names the dev server certificate is issued for, the host of addr included
+func devHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return hosts
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return hosts
+	}
+	for _, h := range hosts {
+		if h == host {
+			return hosts
+		}
+	}
+	return append(hosts, host)
+}
+
+// loadCertPool reads CA certificates of pem files into a pool
+func loadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("client-ca: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("client-ca: no certificates in %s", name)
+		}
+	}
+	return pool, nil
+}
+
+// certReloader serves a certificate from files and loads it again once they change,
+// so renewed certificates are picked up without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu              sync.Mutex
+	cert            *tls.Certificate
+	certMod, keyMod time.Time
+	checked         time.Time
+}
+
+// newCertReloader loads the certificate, failing right away if files are unusable
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the key pair and remembers modification times of the files
+func (c *certReloader) load() error {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.certMod, c.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// modTimes returns modification times of the certificate and key files
+func (c *certReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return
+	}
+	certMod = info.ModTime()
+	if info, err = os.Stat(c.keyFile); err != nil {
+		return
+	}
+	return certMod, info.ModTime(), nil
+}
+
+// GetCertificate returns the current certificate, reloading changed files. a broken update keeps
+// the previous certificate in use, cert and key are often replaced one after another.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < reloadCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	certMod, keyMod, err := c.modTimes()
+	if err != nil || (certMod.Equal(c.certMod) && keyMod.Equal(c.keyMod)) {
+		return c.cert, nil
+	}
+	if err := c.load(); err != nil {
+		log.Printf("tls: keeping previous certificate, reload of %s failed: %s", c.certFile, err)
+		return c.cert, nil
+	}
+	log.Printf("tls: reloaded certificate %s", c.certFile)
+	return c.cert, nil
+}
+
+// ClientIdentity describes the verified certificate a client authenticated with
+type ClientIdentity struct {
+	CommonName     string    `json:"common_name"`
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	SerialNumber   string    `json:"serial_number"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	NotAfter       time.Time `json:"not_after"`
+}
+
+// clientIdentityKey is the context key of the client identity
+type clientIdentityKey struct{}
+
+// ClientIdentityFrom returns identity of the client certificate verified for the request,
+// false if the client sent none or tls isn't used
+func ClientIdentityFrom(ctx context.Context) (*ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return id, ok
+}
+
+// withClientIdentity puts identity of the verified client certificate into the request context
+func withClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cert := r.TLS.VerifiedChains[0][0]
+		id := &ClientIdentity{
+			CommonName:     cert.Subject.CommonName,
+			Subject:        cert.Subject.String(),
+			Issuer:         cert.Issuer.String(),
+			SerialNumber:   cert.SerialNumber.Text(16),
+			DNSNames:       cert.DNSNames,
+			EmailAddresses: cert.EmailAddresses,
+			NotAfter:       cert.NotAfter,
+		}
+		for _, u := range cert.URIs {
+			id.URIs = append(id.URIs, u.String())
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id)))
+	})
+}